@@ -0,0 +1,77 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Default time for which a project the token cannot access is skipped.
+const defaultAccessDeniedTTL = time.Hour
+
+func (l LagOptions) accessDeniedTTL() time.Duration {
+	if l.AccessDeniedTTL > 0 {
+		return time.Duration(l.AccessDeniedTTL)
+	}
+	return defaultAccessDeniedTTL
+}
+
+type projectKey struct {
+	organization string
+	project      string
+}
+
+// accessDeniedTracker remembers the projects for which Sentry answered 403,
+// so that they are skipped until their TTL expires instead of failing on
+// every probe.
+type accessDeniedTracker struct {
+	mu    sync.Mutex
+	until map[projectKey]time.Time
+}
+
+var deniedProjects = &accessDeniedTracker{until: make(map[projectKey]time.Time)}
+
+func (t *accessDeniedTracker) deny(org, project string, ttl time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.until[projectKey{org, project}] = time.Now().Add(ttl)
+}
+
+func (t *accessDeniedTracker) denied(org, project string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := projectKey{org, project}
+	until, ok := t.until[key]
+	if ok && time.Now().After(until) {
+		delete(t.until, key)
+		return false
+	}
+	return ok
+}
+
+// projects returns the projects of the organization which are currently
+// skipped.
+func (t *accessDeniedTracker) projects(org string) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var projects []string
+	for key, until := range t.until {
+		if key.organization == org && time.Now().Before(until) {
+			projects = append(projects, key.project)
+		}
+	}
+	sort.Strings(projects)
+	return projects
+}
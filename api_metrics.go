@@ -0,0 +1,88 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	apiRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "sentry_exporter_api_requests_total",
+		Help: "Number of requests made to the Sentry API, by endpoint and status code",
+	}, []string{"endpoint", "code"})
+	apiRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "sentry_exporter_api_request_duration_seconds",
+		Help: "Duration of the requests made to the Sentry API",
+	}, []string{"endpoint"})
+	apiErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "sentry_exporter_api_errors_total",
+		Help: "Number of requests to the Sentry API which failed or returned an invalid status code",
+	}, []string{"endpoint"})
+	apiResponseTime = prometheus.NewSummaryVec(prometheus.SummaryOpts{
+		Name:       "sentry_exporter_api_response_time_seconds",
+		Help:       "Response time of the Sentry API, by endpoint and by the web worker which served the request",
+		Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+	}, []string{"endpoint", "served_by"})
+	apiServerInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sentry_exporter_api_server_info",
+		Help: "Sentry web workers and versions observed in the responses of the Sentry API",
+	}, []string{"host", "served_by", "version"})
+)
+
+// Response headers identifying the Sentry web worker and version which
+// served a request, when the Sentry installation sets them.
+const (
+	servedByHeader      = "X-Served-By"
+	sentryVersionHeader = "X-Sentry-Version"
+)
+
+func init() {
+	prometheus.MustRegister(apiRequestsTotal, apiRequestDuration, apiErrorsTotal, apiResponseTime, apiServerInfo)
+}
+
+// observeResponse records the response time and the server metadata of a
+// response of the Sentry API.
+func observeResponse(endpoint string, resp *http.Response, duration time.Duration) {
+	servedBy := resp.Header.Get(servedByHeader)
+	apiResponseTime.WithLabelValues(endpoint, servedBy).Observe(duration.Seconds())
+	version := resp.Header.Get(sentryVersionHeader)
+	if servedBy != "" || version != "" {
+		apiServerInfo.WithLabelValues(resp.Request.URL.Host, servedBy, version).Set(1)
+	}
+}
+
+// apiEndpoint returns the API path with the organization, project, issue and
+// release replaced by placeholders, to keep the cardinality of the metrics
+// bounded.
+func apiEndpoint(path string) string {
+	path = strings.SplitN(path, "?", 2)[0]
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	for i := range parts {
+		switch {
+		case i == 1:
+			parts[i] = ":organization"
+		case i == 2 && parts[0] == "projects":
+			parts[i] = ":project"
+		case i > 1 && parts[i-1] == "issues":
+			parts[i] = ":issue"
+		case i > 1 && parts[i-1] == "releases":
+			parts[i] = ":version"
+		}
+	}
+	return strings.Join(parts, "/") + "/"
+}
@@ -0,0 +1,48 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// AuditOptions adds headers to every Sentry API call of a module so that the
+// traffic of the exporter can be traced in gateway logs.
+type AuditOptions struct {
+	Headers map[string]string `yaml:"headers"`
+	// Generates a W3C traceparent header for every request.
+	Traceparent bool `yaml:"traceparent"`
+}
+
+func (a AuditOptions) apply(request *http.Request) {
+	for key, value := range a.Headers {
+		request.Header.Set(key, value)
+	}
+	if a.Traceparent {
+		if traceparent, err := newTraceparent(); err == nil {
+			request.Header.Set("traceparent", traceparent)
+		}
+	}
+}
+
+// newTraceparent returns a sampled traceparent with random trace and parent
+// IDs, see https://www.w3.org/TR/trace-context/#traceparent-header.
+func newTraceparent() (string, error) {
+	ids := make([]byte, 24)
+	if _, err := rand.Read(ids); err != nil {
+		return "", err
+	}
+	return "00-" + hex.EncodeToString(ids[:16]) + "-" + hex.EncodeToString(ids[16:]) + "-01", nil
+}
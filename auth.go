@@ -0,0 +1,205 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	config_util "github.com/prometheus/common/config"
+	"golang.org/x/net/http/httpproxy"
+)
+
+// loadBearerToken resolves the bearer token of the module from either
+// BearerToken, with environment variables expanded, or the contents of
+// BearerTokenFile, along with the tokens of the additional organizations.
+// It is called on every config load so that rotated tokens are picked up on
+// reload.
+func (p *HTTPProbe) loadBearerToken() error {
+	p.organizationTokens = make(map[string]string)
+	for org, token := range p.Organizations {
+		p.organizationTokens[org] = os.ExpandEnv(token)
+	}
+	if p.BearerToken != "" && p.BearerTokenFile != "" {
+		return fmt.Errorf("at most one of bearer_token and bearer_token_file must be configured")
+	}
+	if p.BearerTokenFile != "" {
+		token, err := ioutil.ReadFile(p.BearerTokenFile)
+		if err != nil {
+			return fmt.Errorf("unable to read bearer_token_file: %s", err)
+		}
+		p.bearerToken = strings.TrimSpace(string(token))
+		return nil
+	}
+	p.bearerToken = os.ExpandEnv(p.BearerToken)
+	return nil
+}
+
+// loadTransport builds the transport of the requests to the Sentry API from
+// the TLS and proxy config of the module, which are read on every config
+// load.
+func (p *HTTPProbe) loadTransport() error {
+	tlsConfig, err := config_util.NewTLSConfig(&p.TLSConfig)
+	if err != nil {
+		return err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	// Responses are requested compressed and decompressed transparently.
+	transport.DisableCompression = false
+	// The transport is shared by the probes of the module until the next
+	// config reload, keep a connection idle for every concurrent request so
+	// that the next probe doesn't set them up again.
+	idle := p.maxInFlight()
+	transport.MaxIdleConnsPerHost = idle
+	if transport.MaxIdleConns < idle {
+		transport.MaxIdleConns = idle
+	}
+	if p.ProxyURL != "" {
+		if transport.Proxy, err = proxyFunc(os.ExpandEnv(p.ProxyURL)); err != nil {
+			return err
+		}
+	}
+	p.transport = transport
+	return nil
+}
+
+// proxyFunc returns the proxy of the requests to the given proxy URL, except
+// for the hosts excluded by the NO_PROXY environment variable. Credentials in
+// the URL are sent in a Proxy-Authorization header.
+func proxyFunc(proxyURL string) (func(*http.Request) (*url.URL, error), error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy_url: %s", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("invalid proxy_url %q, the scheme must be http, https or socks5", u.Redacted())
+	}
+	noProxy := os.Getenv("NO_PROXY")
+	if noProxy == "" {
+		noProxy = os.Getenv("no_proxy")
+	}
+	proxy := (&httpproxy.Config{
+		HTTPProxy:  proxyURL,
+		HTTPSProxy: proxyURL,
+		NoProxy:    noProxy,
+	}).ProxyFunc()
+	return func(r *http.Request) (*url.URL, error) {
+		return proxy(r.URL)
+	}, nil
+}
+
+// Default time for which no requests are made with credentials Sentry
+// rejected.
+const defaultAuthFailureCooldown = 5 * time.Minute
+
+var errAuthCoolingDown = errors.New("credentials recently rejected by the Sentry API, cooling down")
+
+func (p HTTPProbe) authFailureCooldown() time.Duration {
+	if p.AuthFailureCooldown > 0 {
+		return time.Duration(p.AuthFailureCooldown)
+	}
+	return defaultAuthFailureCooldown
+}
+
+// isAuthFailure reports whether the response to the API path means that the
+// credentials are rejected as a whole: 401 for any path, or 403 for the
+// organization's endpoints. A 403 for a single project only denies access to
+// that project.
+func isAuthFailure(path string, statusCode int) bool {
+	return statusCode == http.StatusUnauthorized ||
+		(statusCode == http.StatusForbidden && strings.HasPrefix(path, "organizations/"))
+}
+
+// authKey identifies the credentials sent for a request, with a hash of the
+// effective Authorization header rather than the token itself.
+type authKey struct {
+	domain       string
+	organization string
+	tokenHash    string
+}
+
+// authorization returns the Authorization header sent for the API path: the
+// one of the headers for the path if set, else the bearer token.
+func (p HTTPProbe) authorization(path string) string {
+	for key, value := range p.headersFor(path) {
+		if http.CanonicalHeaderKey(key) == "Authorization" {
+			return value
+		}
+	}
+	if p.bearerToken != "" {
+		return "Bearer " + p.bearerToken
+	}
+	return ""
+}
+
+func (p HTTPProbe) authKey(path string) authKey {
+	sum := sha256.Sum256([]byte(p.authorization(path)))
+	return authKey{p.Domain, p.Organization, hex.EncodeToString(sum[:])}
+}
+
+// organizationAuthKey is the key of the credentials sent for the
+// organization's endpoints.
+func (p HTTPProbe) organizationAuthKey() authKey {
+	return p.authKey("organizations/" + p.Organization + "/")
+}
+
+// authFailureTracker remembers the credentials rejected by Sentry, so that
+// probes stop issuing doomed requests until the cool-down expires or the
+// token is changed.
+type authFailureTracker struct {
+	mu    sync.Mutex
+	until map[authKey]time.Time
+}
+
+var authFailures = &authFailureTracker{until: make(map[authKey]time.Time)}
+
+func (t *authFailureTracker) fail(key authKey, cooldown time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.until[key] = time.Now().Add(cooldown)
+}
+
+func (t *authFailureTracker) coolingDown(key authKey) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	until, ok := t.until[key]
+	if ok && time.Now().After(until) {
+		delete(t.until, key)
+		return false
+	}
+	return ok
+}
+
+func registerAuthFailure(registry *prometheus.Registry, key authKey) {
+	authFailureGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "sentry_auth_failure",
+		Help: "Whether requests to the Sentry API are suspended because it recently rejected the credentials",
+	})
+	registry.MustRegister(authFailureGauge)
+	if authFailures.coolingDown(key) {
+		authFailureGauge.Set(1)
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// benchModule returns the module probing the mock Sentry API instead of its
+// configured Sentry, keeping its prober options, retries, concurrency and
+// API call budget. Its projects are not cached yet.
+func benchModule(name string, module Module, mock *mockSentry) Module {
+	module.HTTP.Domain = mock.URL()
+	module.HTTP.Organization = "bench"
+	module.HTTP.Organizations = nil
+	module.HTTP.bearerToken = "bench"
+	module.HTTP.organizationTokens = nil
+	module.HTTP.transport = nil
+	module.HTTP.projectCache = newProjectCache(name, time.Hour)
+	// The rate limits of the Sentry API don't apply to the mock.
+	module.HTTP.quota = nil
+	// The mock implements the issues-stats API.
+	module.HTTP.compat = nil
+	// The mock projects would not match the configured filter.
+	module.HTTP.Projects = ProjectFilter{MaxPages: module.HTTP.Projects.MaxPages}
+	return module
+}
+
+// probeSucceeded returns the value of the probe_success gauge of the probe.
+func probeSucceeded(families []*dto.MetricFamily) bool {
+	for _, mf := range families {
+		if mf.GetName() == "probe_success" && len(mf.GetMetric()) > 0 {
+			return mf.GetMetric()[0].GetGauge().GetValue() == 1
+		}
+	}
+	return false
+}
+
+// benchFlags are the flags of the bench command.
+type benchFlags struct {
+	projects *string
+	latency  *string
+	modules  *string
+	probers  *string
+}
+
+func addBenchCommand(app *kingpin.Application) benchFlags {
+	cmd := app.Command("bench", "Run the probers against an in-process mock Sentry API, print their cost and exit.")
+	return benchFlags{
+		projects: cmd.Flag("projects", "Comma-separated numbers of projects of the mock organization.").Default("10,100,1000").String(),
+		latency:  cmd.Flag("latency", "Comma-separated latencies of the mock Sentry API.").Default("50ms").String(),
+		modules:  cmd.Flag("modules", "Comma-separated modules to benchmark, all of them by default.").Default("").String(),
+		probers:  cmd.Flag("probers", "Comma-separated probers to benchmark, every built-in prober enabled in the module by default.").Default("").String(),
+	}
+}
+
+// runBench runs the built-in probers of every module against the mock Sentry
+// API, for each of the requested project counts and latencies, and writes
+// the probe durations and API call counts to w. The projects are discovered
+// once before running the probers, as they are cached between probes.
+func runBench(conf *Config, flags benchFlags, w io.Writer) error {
+	var projectCounts []int
+	for _, p := range strings.Split(*flags.projects, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid number of projects %q", p)
+		}
+		projectCounts = append(projectCounts, n)
+	}
+	var latencies []time.Duration
+	for _, l := range strings.Split(*flags.latency, ",") {
+		d, err := time.ParseDuration(strings.TrimSpace(l))
+		if err != nil {
+			return fmt.Errorf("invalid latency %q: %s", l, err)
+		}
+		latencies = append(latencies, d)
+	}
+
+	var names []string
+	if *flags.modules != "" {
+		names = strings.Split(*flags.modules, ",")
+	} else {
+		for name := range conf.Modules {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	for _, name := range names {
+		if _, ok := conf.Modules[name]; !ok {
+			return fmt.Errorf("unknown module %q", name)
+		}
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "MODULE\tPROBER\tPROJECTS\tLATENCY\tSUCCESS\tAPI CALLS\tDURATION")
+	for _, name := range names {
+		module := conf.Modules[name]
+
+		var probers []string
+		if *flags.probers != "" {
+			probers = strings.Split(*flags.probers, ",")
+		} else {
+			for prober := range Probers {
+				if module.allowsProber(prober) {
+					probers = append(probers, prober)
+				}
+			}
+			sort.Strings(probers)
+		}
+
+		for _, projects := range projectCounts {
+			for _, latency := range latencies {
+				mock := newMockSentry(projects, latency)
+				bench := benchModule(name, module, mock)
+
+				failures := 0
+				start := time.Now()
+				getOrUpdateProjectsList(bench.HTTP, clientWithTimeout(url.Values{}, bench.HTTP.Lag.Timeout, nil), &failures, prometheus.NewRegistry())
+				fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%t\t%d\t%s\n", name, "discovery", projects, latency, failures == 0, mock.resetRequests(), time.Since(start).Round(time.Millisecond))
+
+				for _, prober := range probers {
+					probe, ok := Probers[prober]
+					if !ok {
+						mock.Close()
+						return fmt.Errorf("unknown built-in prober %q", prober)
+					}
+					start := time.Now()
+					families, err := runProbe(name, probe, url.Values{}, bench).Gather()
+					duration := time.Since(start)
+					if err != nil {
+						mock.Close()
+						return err
+					}
+					fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%t\t%d\t%s\n", name, prober, projects, latency, probeSucceeded(families), mock.resetRequests(), duration.Round(time.Millisecond))
+				}
+				mock.Close()
+			}
+		}
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,131 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"errors"
+	"math"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ScrapeOverrides bounds the values the max_concurrency and max_api_calls
+// parameters of a probe may set. A parameter is ignored when its maximum is
+// not configured.
+type ScrapeOverrides struct {
+	MaxConcurrency int `yaml:"max_concurrency"`
+	MaxAPICalls    int `yaml:"max_api_calls"`
+}
+
+var errAPIBudgetExhausted = errors.New("API call budget of the probe exhausted")
+
+// apiBudget counts the requests to the Sentry API of a probe against its
+// maximum.
+type apiBudget struct {
+	mu        sync.Mutex
+	limit     int
+	calls     int
+	exhausted bool
+}
+
+// take reserves a request, reporting false once the budget is used up.
+func (b *apiBudget) take() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.calls >= b.limit {
+		b.exhausted = true
+		return false
+	}
+	b.calls++
+	return true
+}
+
+// overrideParam returns the value of the parameter if it is set, valid and
+// at most limit.
+func overrideParam(params url.Values, name string, limit int) (int, bool) {
+	v, err := strconv.Atoi(params.Get(name))
+	if err != nil || v <= 0 || limit <= 0 {
+		return 0, false
+	}
+	if v > limit {
+		v = limit
+	}
+	return v, true
+}
+
+// withOverrides applies the max_concurrency and max_api_calls parameters of
+// the probe, within the bounds of ScrapeOverrides, and sets up the API call
+// budget. The refresh parameter forces a refresh of the cached projects.
+func (p HTTPProbe) withOverrides(params url.Values) HTTPProbe {
+	p.refreshProjects = params.Get("refresh") == "true"
+	if v, ok := overrideParam(params, "max_concurrency", p.ScrapeOverrides.MaxConcurrency); ok {
+		p.MaxConcurrency = v
+	}
+	if v, ok := overrideParam(params, "max_api_calls", p.ScrapeOverrides.MaxAPICalls); ok {
+		p.MaxAPICalls = v
+	}
+	if p.MaxAPICalls > 0 {
+		p.apiBudget = &apiBudget{limit: p.MaxAPICalls}
+	}
+	return p
+}
+
+func registerAPIBudget(registry *prometheus.Registry, b *apiBudget) {
+	if b == nil {
+		return
+	}
+	callsGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "sentry_probe_api_calls",
+		Help: "Number of requests to the Sentry API counted against the budget of the probe",
+	})
+	exhaustedGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "sentry_probe_api_budget_exhausted",
+		Help: "Whether requests were skipped because the API call budget of the probe was used up",
+	})
+	registry.MustRegister(callsGauge, exhaustedGauge)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	callsGauge.Set(float64(b.calls))
+	if b.exhausted {
+		exhaustedGauge.Set(1)
+	}
+}
+
+// registerBudgetRemaining exports the share of the API call budget and of
+// the scrape timeout left when the probe, started at start, completed at
+// end. Each is only exported when the probe has that limit.
+func registerBudgetRemaining(registry *prometheus.Registry, config HTTPProbe, start, end time.Time) {
+	remainingGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sentry_probe_budget_remaining_ratio",
+		Help: "Share of the budget of the probe left at its completion, by budget (api_calls or time)",
+	}, []string{"budget"})
+	registry.MustRegister(remainingGauge)
+
+	if b := config.apiBudget; b != nil && b.limit > 0 {
+		b.mu.Lock()
+		remaining := float64(b.limit-b.calls) / float64(b.limit)
+		b.mu.Unlock()
+		remainingGauge.WithLabelValues("api_calls").Set(math.Max(remaining, 0))
+	}
+	if deadline, ok := config.context().Deadline(); ok && deadline.After(start) {
+		remaining := float64(deadline.Sub(end)) / float64(deadline.Sub(start))
+		remainingGauge.WithLabelValues("time").Set(math.Max(remaining, 0))
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"net/url"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var coalescedProbesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "sentry_exporter_coalesced_probes_total",
+	Help: "Number of probes which shared the result of an identical probe already running",
+}, []string{"module"})
+
+func init() {
+	prometheus.MustRegister(coalescedProbesTotal)
+}
+
+type probeCall struct {
+	done     chan struct{}
+	registry *prometheus.Registry
+}
+
+// probeGroup coalesces identical concurrent probes, e.g. from several
+// Prometheus replicas, so that they share a single probe of the Sentry API.
+type probeGroup struct {
+	mu    sync.Mutex
+	calls map[string]*probeCall
+}
+
+var probes = &probeGroup{calls: make(map[string]*probeCall)}
+
+// probeKey identifies the probes of the module and prober with the same
+// parameters, target included.
+func probeKey(moduleName, proberName string, params url.Values) string {
+	return moduleName + "\x00" + proberName + "\x00" + params.Encode()
+}
+
+// do returns the registry of the running probe with the given key, or runs
+// the probe with fn. Waiting probes get the result of the running one, and
+// with it its timeout, or its failure once its scrape was cancelled.
+func (g *probeGroup) do(key, moduleName string, fn func() *prometheus.Registry) *prometheus.Registry {
+	g.mu.Lock()
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		coalescedProbesTotal.WithLabelValues(moduleName).Inc()
+		<-c.done
+		return c.registry
+	}
+	c := &probeCall{done: make(chan struct{})}
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(c.done)
+	}()
+	c.registry = fn()
+	return c.registry
+}
@@ -0,0 +1,224 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+)
+
+var collectionDeferredUntil = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "sentry_exporter_collection_deferred_until_timestamp_seconds",
+	Help: "Time until which the background collection of the prober is deferred because the Sentry API answered with a Retry-After, 0 when it is not",
+}, []string{"module", "prober"})
+
+func init() {
+	prometheus.MustRegister(collectionDeferredUntil)
+}
+
+// CollectOptions enables the background collection of a module. Its probers
+// are run every Interval and their latest results are exposed on /metrics.
+type CollectOptions struct {
+	Interval model.Duration `yaml:"interval"`
+	// Results older than TTL are no longer exposed. Defaults to three
+	// intervals.
+	TTL     model.Duration    `yaml:"ttl"`
+	Probers []string          `yaml:"probers"`
+	Params  map[string]string `yaml:"params"`
+}
+
+func (c CollectOptions) ttl() time.Duration {
+	if c.TTL > 0 {
+		return time.Duration(c.TTL)
+	}
+	return 3 * time.Duration(c.Interval)
+}
+
+type collectedResult struct {
+	families  []*dto.MetricFamily
+	collected time.Time
+	ttl       time.Duration
+}
+
+type collectorKey struct {
+	module string
+	prober string
+}
+
+// BackgroundCollector runs the probers of the modules with collection
+// enabled on their interval, and gathers their latest results.
+type BackgroundCollector struct {
+	mu       sync.RWMutex
+	results  map[collectorKey]collectedResult
+	stop     chan struct{}
+	notifier *alertNotifier
+}
+
+func newBackgroundCollector() *BackgroundCollector {
+	return &BackgroundCollector{
+		results:  make(map[collectorKey]collectedResult),
+		notifier: newAlertNotifier(),
+	}
+}
+
+// start stops any previously started collection and begins collecting the
+// modules of the given config.
+func (bc *BackgroundCollector) start(conf *Config) {
+	bc.mu.Lock()
+	if bc.stop != nil {
+		close(bc.stop)
+	}
+	bc.stop = make(chan struct{})
+	bc.results = make(map[collectorKey]collectedResult)
+	stop := bc.stop
+	bc.mu.Unlock()
+	collectionDeferredUntil.Reset()
+
+	for name, module := range conf.Modules {
+		if module.Collect.Interval <= 0 {
+			continue
+		}
+		for _, proberName := range module.Collect.Probers {
+			prober, ok := module.lookupProber(proberName)
+			if !ok {
+				log.Errorf("Unknown prober %q for background collection of module %s", proberName, name)
+				continue
+			}
+			go bc.run(collectorKey{module: name, prober: proberName}, prober, module, stop)
+		}
+	}
+}
+
+func (bc *BackgroundCollector) run(key collectorKey, prober ProbeFn, module Module, stop <-chan struct{}) {
+	params := url.Values{}
+	for k, v := range module.Collect.Params {
+		params.Set(k, v)
+	}
+	log.Infof("Starting background collection of prober %s for module %s every %s", key.prober, key.module, module.Collect.Interval)
+
+	for {
+		next := time.Now().Add(time.Duration(module.Collect.Interval))
+		// A Retry-After longer than the interval defers the next
+		// collection, rather than requesting the throttled API again.
+		if deferred := bc.collect(key, prober, params, module, stop); deferred.After(next) {
+			log.Warnf("Sentry API throttled prober %s of module %s, deferring its collection until %s", key.prober, key.module, deferred.Format(time.RFC3339))
+			collectionDeferredUntil.WithLabelValues(key.module, key.prober).Set(float64(deferred.Unix()))
+			next = deferred
+		} else {
+			collectionDeferredUntil.WithLabelValues(key.module, key.prober).Set(0)
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// collect runs the prober and stores its results, returning the time until
+// which the Sentry API asked not to be requested, zero if it didn't.
+func (bc *BackgroundCollector) collect(key collectorKey, prober ProbeFn, params url.Values, module Module, stop <-chan struct{}) time.Time {
+	module.HTTP.throttle = &throttleHint{}
+	families, err := runProbe(key.module, prober, params, module).Gather()
+	deferred := module.HTTP.throttle.deferredUntil()
+	if err != nil {
+		log.Errorf("Error gathering background collection of prober %s for module %s: %s", key.prober, key.module, err)
+		return deferred
+	}
+
+	bc.mu.Lock()
+	select {
+	case <-stop:
+		// The config was reloaded while probing.
+		bc.mu.Unlock()
+		return deferred
+	default:
+	}
+	bc.results[key] = collectedResult{
+		families:  families,
+		collected: time.Now(),
+		ttl:       module.Collect.ttl(),
+	}
+	bc.mu.Unlock()
+
+	bc.notifier.notify(key, module, families)
+	return deferred
+}
+
+// Gather implements prometheus.Gatherer, returning the results which are
+// not stale with module and prober labels added.
+func (bc *BackgroundCollector) Gather() ([]*dto.MetricFamily, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	merged := make(map[string]*dto.MetricFamily)
+	for key, result := range bc.results {
+		if time.Since(result.collected) > result.ttl {
+			continue
+		}
+		for _, mf := range result.families {
+			out, ok := merged[mf.GetName()]
+			if !ok {
+				out = &dto.MetricFamily{Name: mf.Name, Help: mf.Help, Type: mf.Type}
+				merged[mf.GetName()] = out
+			}
+			for _, m := range mf.Metric {
+				out.Metric = append(out.Metric, withLabels(m, map[string]string{
+					"module": key.module,
+					"prober": key.prober,
+				}))
+			}
+		}
+	}
+
+	var families []*dto.MetricFamily
+	for _, mf := range merged {
+		families = append(families, mf)
+	}
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].GetName() < families[j].GetName()
+	})
+	return families, nil
+}
+
+// withLabels returns a copy of the metric with the given labels added,
+// unless the metric already has a label of the same name.
+func withLabels(m *dto.Metric, labels map[string]string) *dto.Metric {
+	out := proto.Clone(m).(*dto.Metric)
+	for name, value := range labels {
+		exists := false
+		for _, lp := range out.Label {
+			if lp.GetName() == name {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			out.Label = append(out.Label, &dto.LabelPair{Name: proto.String(name), Value: proto.String(value)})
+		}
+	}
+	sort.Slice(out.Label, func(i, j int) bool {
+		return out.Label[i].GetName() < out.Label[j].GetName()
+	})
+	return out
+}
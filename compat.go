@@ -0,0 +1,96 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Code paths counting the events of the issues.
+const (
+	issueCountsFromStats = "issues_stats"
+	issueCountsFromList  = "issues_list"
+)
+
+var apiCompatInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "sentry_exporter_api_compat_info",
+	Help: "Code path used for the APIs a Sentry server may lack, issue_counts being issues_stats or issues_list",
+}, []string{"module", "issue_counts"})
+
+func init() {
+	prometheus.MustRegister(apiCompatInfo)
+}
+
+// apiCompat tracks the APIs the Sentry server of a module turned out to
+// lack, such as the issues-stats API missing from Sentry 21.x, shared until
+// the next config reload.
+type apiCompat struct {
+	module string
+
+	mu               sync.Mutex
+	listsIssueCounts bool
+}
+
+// newAPICompat returns the compatibility state of the module, counting the
+// events of the issues from the issues list from the start for flavors
+// without the issues-stats API.
+func newAPICompat(module string, p HTTPProbe) *apiCompat {
+	c := &apiCompat{module: module}
+	c.setIssueCountsFromList(p.glitchTip())
+	return c
+}
+
+func (c *apiCompat) setIssueCountsFromList(list bool) {
+	c.listsIssueCounts = list
+	path, other := issueCountsFromStats, issueCountsFromList
+	if list {
+		path, other = other, path
+	}
+	apiCompatInfo.DeleteLabelValues(c.module, other)
+	apiCompatInfo.WithLabelValues(c.module, path).Set(1)
+}
+
+// issueCountsFromList reports whether the events of the issues are counted
+// from the issues list rather than the issues-stats API.
+func (p HTTPProbe) issueCountsFromList() bool {
+	if p.compat == nil {
+		return p.glitchTip()
+	}
+	p.compat.mu.Lock()
+	defer p.compat.mu.Unlock()
+	return p.compat.listsIssueCounts
+}
+
+// fallBackToIssuesList switches the module to counting the events of the
+// issues from the issues list, once the issues-stats API answered with a 404.
+func (p HTTPProbe) fallBackToIssuesList() {
+	if p.compat == nil {
+		return
+	}
+	p.compat.mu.Lock()
+	defer p.compat.mu.Unlock()
+	if !p.compat.listsIssueCounts {
+		p.logger().Warnf("The issues-stats API is missing, counting the events of the issues from the issues list")
+		p.compat.setIssueCountsFromList(true)
+	}
+}
+
+// isNotFound reports whether the request failed because the Sentry API has
+// no such endpoint or resource.
+func isNotFound(err error) bool {
+	e, ok := err.(*sentryResponseError)
+	return ok && e.statusCode == http.StatusNotFound
+}
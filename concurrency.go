@@ -0,0 +1,75 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import "sync"
+
+// Default number of projects fetched at once by a probe.
+const defaultMaxConcurrency = 10
+
+func (p HTTPProbe) maxConcurrency() int {
+	if p.MaxConcurrency > 0 {
+		return p.MaxConcurrency
+	}
+	return defaultMaxConcurrency
+}
+
+// maxInFlight returns the largest number of requests to the Sentry API made
+// at once by a probe of the module: its organizations probed concurrently,
+// times its projects fetched at once, possibly raised per scrape, times the
+// requests of a project made at once by the lag and canary probers, or the
+// max_concurrency of an endpoint limit if larger.
+func (p HTTPProbe) maxInFlight() int {
+	projects := p.maxConcurrency()
+	if o := p.ScrapeOverrides.MaxConcurrency; o > projects {
+		projects = o
+	}
+	orgs := len(p.organizations())
+	if orgs == 0 {
+		orgs = 1
+	}
+	// The canary prober makes every request of the lag prober.
+	lag := canaryLagOptions(p.Lag)
+	n := orgs * projects * lag.statsConcurrency(len(lag.projectRequests()))
+	for _, l := range p.EndpointLimits {
+		if l.MaxConcurrency > n {
+			n = l.MaxConcurrency
+		}
+	}
+	return n
+}
+
+// forEachTarget calls fn for every target, with at most limit calls running
+// at once, and waits for them to return. No further targets are started once
+// stop, if not nil, returns true. It returns the number of targets started.
+func forEachTarget(targets []string, limit int, stop func() bool, fn func(target string)) int {
+	sem := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	started := 0
+	for _, t := range targets {
+		sem <- struct{}{}
+		if stop != nil && stop() {
+			<-sem
+			break
+		}
+		wg.Add(1)
+		started++
+		go func(t string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fn(t)
+		}(t)
+	}
+	wg.Wait()
+	return started
+}
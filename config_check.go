@@ -0,0 +1,99 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+)
+
+// problems returns the required settings missing from the module, which
+// would only make its probes fail.
+func (p HTTPProbe) problems() []string {
+	var problems []string
+	if p.Domain == "" {
+		problems = append(problems, "domain is missing, e.g. https://sentry.io")
+	}
+	if p.Organization == "" {
+		problems = append(problems, "organization is missing, the slug of the Sentry organization to probe")
+	}
+	switch {
+	case p.authorization("organizations/"+p.Organization+"/") != "", p.hasAuthorizationHeader():
+	case p.BearerToken != "":
+		problems = append(problems, fmt.Sprintf("bearer_token %q is empty once expanded, is the environment variable set?", p.BearerToken))
+	case p.BearerTokenFile != "":
+		problems = append(problems, fmt.Sprintf("bearer_token_file %s is empty", p.BearerTokenFile))
+	default:
+		problems = append(problems, "no credentials, set bearer_token, bearer_token_file or an Authorization header")
+	}
+	var orgs []string
+	for org := range p.Organizations {
+		orgs = append(orgs, org)
+	}
+	sort.Strings(orgs)
+	for _, org := range orgs {
+		if token := p.Organizations[org]; token != "" && p.organizationTokens[org] == "" {
+			problems = append(problems, fmt.Sprintf("token %q of organization %s is empty once expanded, is the environment variable set?", token, org))
+		}
+	}
+	return problems
+}
+
+// hasAuthorizationHeader reports whether an Authorization header is
+// configured for the module or one of its endpoints.
+func (p HTTPProbe) hasAuthorizationHeader() bool {
+	headers := []map[string]string{p.Headers}
+	for _, e := range p.EndpointHeaders {
+		headers = append(headers, e.Headers)
+	}
+	for _, h := range headers {
+		for key := range h {
+			if http.CanonicalHeaderKey(key) == "Authorization" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkConfig loads the config file with strict parsing, so that misspelled
+// or misindented keys are reported, and checks the required settings of
+// every module. It prints the problems found and returns whether there were
+// none.
+func checkConfig(confFile string, out io.Writer) bool {
+	c, err := loadConfig(confFile, true)
+	if err != nil {
+		fmt.Fprintf(out, "FAILED: %s\n", err)
+		return false
+	}
+
+	var names []string
+	for name := range c.Modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	ok := true
+	for _, name := range names {
+		for _, problem := range c.Modules[name].HTTP.problems() {
+			fmt.Fprintf(out, "FAILED: module %s: %s\n", name, problem)
+			ok = false
+		}
+	}
+	if ok {
+		fmt.Fprintf(out, "SUCCESS: %s is valid, %d module(s) checked\n", confFile, len(names))
+	}
+	return ok
+}
@@ -0,0 +1,53 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckConfigCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config_check")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, c := range []struct {
+		name   string
+		config string
+		ok     bool
+	}{
+		{"header", "headers:\n        Authorization: Bearer token", true},
+		{"bearer_token", "bearer_token: token", true},
+		{"none", "max_concurrency: 1", false},
+	} {
+		confFile := filepath.Join(dir, c.name+".yml")
+		config := "modules:\n  sentry:\n    http:\n      domain: https://sentry.io\n      organization: o\n      " + c.config + "\n"
+		if err := ioutil.WriteFile(confFile, []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		if ok := checkConfig(confFile, &out); ok != c.ok {
+			t.Errorf("%s: checkConfig = %t, want %t:\n%s", c.name, ok, c.ok, out.String())
+		}
+		if !c.ok && !strings.Contains(out.String(), "no credentials") {
+			t.Errorf("%s: no credentials problem reported:\n%s", c.name, out.String())
+		}
+	}
+}
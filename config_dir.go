@@ -0,0 +1,117 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+)
+
+// configDirFiles returns the *.yml files of the config directory, sorted by
+// name.
+func configDirFiles(dir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.yml"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// readConfig returns the config file, or the *.yml files of the config
+// directory merged into a single config. With strict, every file of the
+// directory is parsed strictly on its own, so that errors point to the
+// file and line they come from.
+func readConfig(confFile string, strict bool) ([]byte, error) {
+	info, err := os.Stat(confFile)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return ioutil.ReadFile(confFile)
+	}
+	files, err := configDirFiles(confFile)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no *.yml files in config directory %s", confFile)
+	}
+
+	merged := make(map[interface{}]interface{})
+	// Files setting every merged key, for the errors of duplicates.
+	origins := make(map[string]string)
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if strict {
+			if err := yaml.UnmarshalStrict(data, &Config{}); err != nil {
+				return nil, fmt.Errorf("%s: %s", file, err)
+			}
+		}
+		var raw map[interface{}]interface{}
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			return nil, fmt.Errorf("%s: %s", file, err)
+		}
+		if err := mergeConfigFile(merged, raw, file, origins); err != nil {
+			return nil, err
+		}
+	}
+	return yaml.Marshal(merged)
+}
+
+// mergeConfigFile merges the raw yaml of a config file into the config
+// merged from the previous files. Lists, such as module_templates, are
+// appended and maps, such as modules, are merged, a key being set by a
+// single file.
+func mergeConfigFile(merged, raw map[interface{}]interface{}, file string, origins map[string]string) error {
+	for key, value := range raw {
+		section := fmt.Sprint(key)
+		switch v := value.(type) {
+		case nil:
+			continue
+		case []interface{}:
+			items, _ := merged[key].([]interface{})
+			merged[key] = append(items, v...)
+			continue
+		case map[interface{}]interface{}:
+			m, ok := merged[key].(map[interface{}]interface{})
+			if !ok {
+				m = make(map[interface{}]interface{}, len(v))
+				merged[key] = m
+			}
+			for k, value := range v {
+				name := fmt.Sprintf("%s.%v", section, k)
+				if origin, ok := origins[name]; ok {
+					return fmt.Errorf("%s is set in both %s and %s", name, origin, file)
+				}
+				origins[name] = file
+				m[k] = value
+			}
+			continue
+		}
+		if origin, ok := origins[section]; ok {
+			return fmt.Errorf("%s is set in both %s and %s", section, origin, file)
+		}
+		origins[section] = file
+		merged[key] = value
+	}
+	return nil
+}
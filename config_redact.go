@@ -0,0 +1,160 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Replaces the secrets of the config served by /config, like Prometheus.
+const secretPlaceholder = "<secret>"
+
+// sensitiveHeaders are the parts of the names of the headers and exec prober
+// flags whose values are redacted.
+var sensitiveHeaders = []string{"authorization", "cookie", "token", "secret", "password", "key"}
+
+func redactedHeaders(headers map[string]string) map[string]string {
+	if headers == nil {
+		return nil
+	}
+	out := make(map[string]string, len(headers))
+	for name, value := range headers {
+		if isSensitive(name) {
+			value = secretPlaceholder
+		}
+		out[name] = value
+	}
+	return out
+}
+
+func isSensitive(name string) bool {
+	for _, s := range sensitiveHeaders {
+		if strings.Contains(strings.ToLower(name), s) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactedArgs returns the arguments of an exec prober with the values of the
+// sensitive flags redacted, whether given as --token=value or as the
+// argument following --token.
+func redactedArgs(args []string) []string {
+	if args == nil {
+		return nil
+	}
+	out := make([]string, len(args))
+	secret := false
+	for i, arg := range args {
+		switch {
+		case secret:
+			arg = secretPlaceholder
+			secret = false
+		case strings.HasPrefix(arg, "-") && strings.Contains(arg, "="):
+			if name := arg[:strings.Index(arg, "=")]; isSensitive(name) {
+				arg = name + "=" + secretPlaceholder
+			}
+		case strings.HasPrefix(arg, "-"):
+			secret = isSensitive(arg)
+		}
+		out[i] = arg
+	}
+	return out
+}
+
+// redactedURL returns the URL with the password of its user info redacted.
+func redactedURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return secretPlaceholder
+	}
+	return u.Redacted()
+}
+
+func (p HTTPProbe) redacted() HTTPProbe {
+	if p.BearerToken != "" {
+		p.BearerToken = secretPlaceholder
+	}
+	if p.Organizations != nil {
+		orgs := make(map[string]string, len(p.Organizations))
+		for org, token := range p.Organizations {
+			if token != "" {
+				token = secretPlaceholder
+			}
+			orgs[org] = token
+		}
+		p.Organizations = orgs
+	}
+	p.Headers = redactedHeaders(p.Headers)
+	p.Audit.Headers = redactedHeaders(p.Audit.Headers)
+	endpointHeaders := make([]EndpointHeaders, len(p.EndpointHeaders))
+	for i, e := range p.EndpointHeaders {
+		e.Headers = redactedHeaders(e.Headers)
+		endpointHeaders[i] = e
+	}
+	p.EndpointHeaders = endpointHeaders
+	if p.ProxyURL != "" {
+		p.ProxyURL = redactedURL(p.ProxyURL)
+	}
+	return p
+}
+
+// redacted returns a copy of the config with the tokens, the values of the
+// authentication headers and exec prober flags and the passwords of URLs
+// replaced by <secret>.
+// Module templates are left out, their instances being among the modules.
+func (c *Config) redacted() *Config {
+	out := &Config{
+		Modules:       make(map[string]Module, len(c.Modules)),
+		ModuleAliases: c.ModuleAliases,
+	}
+	for name, m := range c.Modules {
+		m.HTTP = m.HTTP.redacted()
+		if m.ProbeTokens != nil {
+			tokens := make([]string, len(m.ProbeTokens))
+			for i := range tokens {
+				tokens[i] = secretPlaceholder
+			}
+			m.ProbeTokens = tokens
+		}
+		if m.Alerts.URL != "" {
+			m.Alerts.URL = redactedURL(m.Alerts.URL)
+		}
+		if m.ExecProbers != nil {
+			execProbers := make(map[string]ExecProber, len(m.ExecProbers))
+			for name, e := range m.ExecProbers {
+				e.Args = redactedArgs(e.Args)
+				execProbers[name] = e
+			}
+			m.ExecProbers = execProbers
+		}
+		out.Modules[name] = m
+	}
+	return out
+}
+
+// configHandler serves the loaded config as YAML, with its secrets redacted.
+func configHandler(w http.ResponseWriter, r *http.Request, conf *Config) {
+	out, err := yaml.Marshal(conf.redacted())
+	if err != nil {
+		log.Errorf("Error marshalling the config: %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(out)
+}
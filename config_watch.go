@@ -0,0 +1,170 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Time for which the watched files must not change before the config is
+// reloaded, so that an editor or a ConfigMap update writing several files
+// triggers a single reload.
+const configWatchDebounce = time.Second
+
+var (
+	configLastReloadSuccessful = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "sentry_exporter_config_last_reload_successful",
+		Help: "Whether the last configuration reload attempt was successful",
+	})
+	configLastReloadSuccessTimestamp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "sentry_exporter_config_last_reload_success_timestamp_seconds",
+		Help: "Timestamp of the last successful configuration reload",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(configLastReloadSuccessful, configLastReloadSuccessTimestamp)
+}
+
+// recordReload records the outcome of a configuration reload attempt.
+func recordReload(err error) {
+	if err != nil {
+		configLastReloadSuccessful.Set(0)
+		return
+	}
+	configLastReloadSuccessful.Set(1)
+	configLastReloadSuccessTimestamp.SetToCurrentTime()
+}
+
+// watchedFiles returns the config file or directory along with the
+// bearer_token_file of every module of the config.
+func watchedFiles(confFile string, conf *Config) []string {
+	files := []string{confFile}
+	for _, module := range conf.Modules {
+		if f := module.HTTP.BearerTokenFile; f != "" {
+			files = append(files, f)
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+// configWatcher watches the directories of the config and token files, so
+// that files replaced by a rename, such as the symlinks of a Kubernetes
+// ConfigMap, are noticed.
+type configWatcher struct {
+	watcher *fsnotify.Watcher
+	// Sent once the watched files stopped changing.
+	changed chan struct{}
+
+	mu    sync.Mutex
+	files map[string]bool
+	dirs  map[string]bool
+	// Config directories, whose *.yml files are all watched.
+	configDirs map[string]bool
+}
+
+func newConfigWatcher() (*configWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	return &configWatcher{
+		watcher:    watcher,
+		changed:    make(chan struct{}, 1),
+		files:      make(map[string]bool),
+		dirs:       make(map[string]bool),
+		configDirs: make(map[string]bool),
+	}, nil
+}
+
+// watch replaces the watched files, e.g. with the token files of a reloaded
+// config.
+func (w *configWatcher) watch(files []string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.files = make(map[string]bool)
+	w.configDirs = make(map[string]bool)
+	for _, f := range files {
+		f = filepath.Clean(f)
+		dir := filepath.Dir(f)
+		if info, err := os.Stat(f); err == nil && info.IsDir() {
+			// Added and removed files are noticed too.
+			w.configDirs[f] = true
+			dir = f
+		} else {
+			w.files[f] = true
+		}
+		if w.dirs[dir] {
+			continue
+		}
+		if err := w.watcher.Add(dir); err != nil {
+			log.Errorf("Error watching %s for configuration changes: %s", dir, err)
+			continue
+		}
+		w.dirs[dir] = true
+	}
+}
+
+// relevant reports whether the event changes a watched file, a *.yml file
+// of a config directory, or the data of a ConfigMap or Secret volume in a
+// watched directory, which swaps its ..data symlink.
+func (w *configWatcher) relevant(event fsnotify.Event) bool {
+	if event.Op == fsnotify.Chmod {
+		return false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	name := filepath.Clean(event.Name)
+	if w.configDirs[filepath.Dir(name)] && filepath.Ext(name) == ".yml" {
+		return true
+	}
+	return w.files[name] || strings.HasPrefix(filepath.Base(name), "..")
+}
+
+// run sends on changed once the watched files stopped changing for
+// configWatchDebounce.
+func (w *configWatcher) run() {
+	var debounce <-chan time.Time
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			if w.relevant(event) {
+				log.Debugf("Configuration file %s changed: %s", event.Name, event.Op)
+				debounce = time.After(configWatchDebounce)
+			}
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Errorf("Error watching the configuration files: %s", err)
+		case <-debounce:
+			debounce = nil
+			select {
+			case w.changed <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/promlog"
+)
+
+// logger returns the logger of the probe, which also captures the log lines
+// into the debug output when the probe was requested with ?debug=true.
+func (p HTTPProbe) logger() *Logger {
+	if p.probeLogger != nil {
+		return p.probeLogger
+	}
+	return log
+}
+
+// newDebugLogger returns a logger writing every line to buf, debug level
+// included, while the exporter's logger keeps logging the lines allowed by
+// its own level.
+func newDebugLogger(buf io.Writer) *Logger {
+	debug := &promlog.AllowedLevel{}
+	debug.Set("debug")
+	captured := newFilteredLogger(buf, &promlog.Config{Level: debug})
+	process := log.filtered
+	return wrapLogger(kitlog.LoggerFunc(func(keyvals ...interface{}) error {
+		captured.Log(keyvals...)
+		return process.Log(keyvals...)
+	}))
+}
+
+// writeDebugOutput writes the captured log lines of the probe followed by
+// the metrics it would have returned.
+func writeDebugOutput(w http.ResponseWriter, logs *bytes.Buffer, registry *prometheus.Registry) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "Logs for the probe:\n%s\n\n", logs.String())
+	fmt.Fprintf(w, "Metrics that would have been returned:\n")
+	families, err := registry.Gather()
+	if err != nil {
+		fmt.Fprintf(w, "Error gathering metrics: %s\n", err)
+	}
+	for _, mf := range families {
+		expfmt.MetricFamilyToText(w, mf)
+	}
+}
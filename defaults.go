@@ -0,0 +1,74 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"gopkg.in/yaml.v2"
+)
+
+// mergeDefaults returns the raw yaml value with the keys of the defaults it
+// doesn't set added. Maps, such as headers, are merged key by key while any
+// other value set by the module, lists included, overrides the default.
+func mergeDefaults(raw, defaults interface{}) interface{} {
+	d, ok := defaults.(map[interface{}]interface{})
+	if !ok {
+		if raw == nil {
+			return defaults
+		}
+		return raw
+	}
+	if raw == nil {
+		raw = map[interface{}]interface{}{}
+	}
+	v, ok := raw.(map[interface{}]interface{})
+	if !ok {
+		// Reported when parsing the config itself.
+		return raw
+	}
+	m := make(map[interface{}]interface{}, len(v)+len(d))
+	for key, value := range v {
+		m[key] = value
+	}
+	for key, value := range d {
+		m[key] = mergeDefaults(m[key], value)
+	}
+	return m
+}
+
+// applyModuleDefaults returns the config file with the defaults merged into
+// the http of every module, template instances included, so that modules
+// differing only in a few fields don't repeat the others.
+func applyModuleDefaults(data []byte) ([]byte, error) {
+	var raw map[interface{}]interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil || raw["defaults"] == nil {
+		// Errors are reported when parsing the config itself.
+		return data, nil
+	}
+	modules, _ := raw["modules"].(map[interface{}]interface{})
+	for name, module := range modules {
+		if module == nil {
+			module = map[interface{}]interface{}{}
+		}
+		m, ok := module.(map[interface{}]interface{})
+		if !ok {
+			continue
+		}
+		out := make(map[interface{}]interface{}, len(m)+1)
+		for key, value := range m {
+			out[key] = value
+		}
+		out["http"] = mergeDefaults(m["http"], raw["defaults"])
+		modules[name] = out
+	}
+	return yaml.Marshal(raw)
+}
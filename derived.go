@@ -0,0 +1,179 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// DerivedMetric describes a series computed from two other series collected
+// during the same probe, e.g. the rejected/received ratio of a project.
+type DerivedMetric struct {
+	Name  string         `yaml:"name"`
+	Op    string         `yaml:"op"`
+	Left  MetricSelector `yaml:"left"`
+	Right MetricSelector `yaml:"right"`
+}
+
+// MetricSelector selects the series of a metric whose labels match the given
+// values. The matched labels are dropped and the remaining labels are used to
+// join the left and right hand sides of a DerivedMetric.
+type MetricSelector struct {
+	Metric string            `yaml:"metric"`
+	Labels map[string]string `yaml:"labels"`
+}
+
+var derivedOps = map[string]func(float64, float64) (float64, bool){
+	"add":      func(a, b float64) (float64, bool) { return a + b, true },
+	"subtract": func(a, b float64) (float64, bool) { return a - b, true },
+	"multiply": func(a, b float64) (float64, bool) { return a * b, true },
+	"divide": func(a, b float64) (float64, bool) {
+		if b == 0 {
+			return 0, false
+		}
+		return a / b, true
+	},
+}
+
+func (d DerivedMetric) validate() error {
+	if d.Name == "" {
+		return fmt.Errorf("derived metric is missing a name")
+	}
+	if _, ok := derivedOps[d.Op]; !ok {
+		return fmt.Errorf("derived metric %s has unknown op %q", d.Name, d.Op)
+	}
+	if d.Left.Metric == "" || d.Right.Metric == "" {
+		return fmt.Errorf("derived metric %s requires both left and right metrics", d.Name)
+	}
+	return nil
+}
+
+type derivedSample struct {
+	labels map[string]string
+	value  float64
+}
+
+// selectSamples returns the samples matching the selector keyed by the
+// label set left over once the selector's own labels are removed.
+func selectSamples(families map[string]*dto.MetricFamily, sel MetricSelector) map[string]derivedSample {
+	ret := make(map[string]derivedSample)
+	mf, ok := families[sel.Metric]
+	if !ok {
+		return ret
+	}
+	for _, m := range mf.Metric {
+		labels := make(map[string]string)
+		matched := 0
+		for _, lp := range m.Label {
+			if want, ok := sel.Labels[lp.GetName()]; ok {
+				if want != lp.GetValue() {
+					break
+				}
+				matched++
+				continue
+			}
+			labels[lp.GetName()] = lp.GetValue()
+		}
+		if matched != len(sel.Labels) {
+			continue
+		}
+		ret[labelsKey(labels)] = derivedSample{labels: labels, value: sampleValue(m)}
+	}
+	return ret
+}
+
+func sampleValue(m *dto.Metric) float64 {
+	switch {
+	case m.Gauge != nil:
+		return m.Gauge.GetValue()
+	case m.Counter != nil:
+		return m.Counter.GetValue()
+	case m.Untyped != nil:
+		return m.Untyped.GetValue()
+	}
+	return 0
+}
+
+func sortedLabelNames(labels map[string]string) []string {
+	var names []string
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func labelsKey(labels map[string]string) string {
+	var parts []string
+	for _, name := range sortedLabelNames(labels) {
+		parts = append(parts, name+"\xff"+labels[name])
+	}
+	return strings.Join(parts, "\xfe")
+}
+
+// registerDerivedMetrics evaluates the module's derived metrics against the
+// metrics recorded by a prober and registers the results as gauges.
+func registerDerivedMetrics(registry *prometheus.Registry, derived []DerivedMetric) {
+	if len(derived) == 0 {
+		return
+	}
+	mfs, err := registry.Gather()
+	if err != nil {
+		log.Errorf("Error gathering probe metrics for derived metrics: %s", err)
+		return
+	}
+	families := make(map[string]*dto.MetricFamily)
+	for _, mf := range mfs {
+		families[mf.GetName()] = mf
+	}
+
+	for _, d := range derived {
+		op := derivedOps[d.Op]
+		left := selectSamples(families, d.Left)
+		right := selectSamples(families, d.Right)
+
+		var gauge *prometheus.GaugeVec
+		var labelNames []string
+		for key, l := range left {
+			r, ok := right[key]
+			if !ok {
+				continue
+			}
+			v, ok := op(l.value, r.value)
+			if !ok {
+				continue
+			}
+			if gauge == nil {
+				labelNames = sortedLabelNames(l.labels)
+				gauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+					Name: d.Name,
+					Help: fmt.Sprintf("Derived metric: %s %s %s", d.Left.Metric, d.Op, d.Right.Metric),
+				}, labelNames)
+				if err := registry.Register(gauge); err != nil {
+					log.Errorf("Error registering derived metric %s: %s", d.Name, err)
+					break
+				}
+			}
+			var values []string
+			for _, name := range labelNames {
+				values = append(values, l.labels[name])
+			}
+			gauge.WithLabelValues(values...).Set(v)
+		}
+	}
+}
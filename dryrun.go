@@ -0,0 +1,150 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// scrapeEstimate is the expected cost of a single probe.
+type scrapeEstimate struct {
+	apiCalls int
+	duration time.Duration
+}
+
+// estimateScrape estimates the cost of running the built-in prober against
+// the given number of projects, based on the latency of a single API call.
+// Probers whose cost does not depend on the configuration return false.
+func estimateScrape(prober string, module Module, projects int, latency time.Duration) (scrapeEstimate, bool) {
+	// Number of batches of projects fetched at once.
+	concurrency := module.HTTP.maxConcurrency()
+	rounds := time.Duration((projects + concurrency - 1) / concurrency)
+	switch prober {
+	case "lag":
+		// The requests of a project are made concurrently.
+		calls := len(module.HTTP.Lag.projectRequests())
+		concurrency := module.HTTP.Lag.statsConcurrency(calls)
+		sequential := (calls + concurrency - 1) / concurrency
+		return scrapeEstimate{
+			apiCalls: projects * calls,
+			duration: rounds * time.Duration(sequential) * latency,
+		}, true
+	case "canary":
+		canaries := len(module.HTTP.CanaryProjects)
+		lag := canaryLagOptions(module.HTTP.Lag)
+		calls := len(lag.projectRequests())
+		concurrency := lag.statsConcurrency(calls)
+		canaryRounds := time.Duration((canaries + module.HTTP.maxConcurrency() - 1) / module.HTTP.maxConcurrency())
+		return scrapeEstimate{
+			apiCalls: canaries * calls,
+			duration: canaryRounds * time.Duration((calls+concurrency-1)/concurrency) * latency,
+		}, true
+	case "issue_counts":
+		// The project IDs, then the counts of each project.
+		return scrapeEstimate{
+			apiCalls: 1 + projects,
+			duration: latency + rounds*latency,
+		}, true
+	case "teams":
+		// The stats of each project, its teams being cached along with the
+		// projects, then at least one page of issues and its stats.
+		return scrapeEstimate{
+			apiCalls: 2 + projects,
+			duration: rounds*latency + 2*latency,
+		}, true
+	case "latest_event", "processing_issues":
+		return scrapeEstimate{
+			apiCalls: projects,
+			duration: rounds * latency,
+		}, true
+	case "releases":
+		// The project IDs, then the environments of each project and the
+		// latest release and its deploys, assuming a single environment.
+		return scrapeEstimate{
+			apiCalls: 1 + 3*projects,
+			duration: latency + rounds*3*latency,
+		}, true
+	case "keys":
+		// The keys of each project and their stats, assuming a single key.
+		return scrapeEstimate{
+			apiCalls: 2 * projects,
+			duration: rounds * 2 * latency,
+		}, true
+	case "sessions", "outcomes", "ingest":
+		// The project IDs and the stats of the whole organization.
+		return scrapeEstimate{apiCalls: 2, duration: 2 * latency}, true
+	case "monitors":
+		// At least one page of monitors.
+		return scrapeEstimate{apiCalls: 1, duration: latency}, true
+	case "incidents":
+		// At least one page of alert rules and of open incidents.
+		return scrapeEstimate{apiCalls: 2, duration: 2 * latency}, true
+	case "issues":
+		// At least one page of issues and its stats, and of teams for
+		// per-team metrics.
+		if module.HTTP.Issues.Owners {
+			return scrapeEstimate{apiCalls: 3, duration: 3 * latency}, true
+		}
+		return scrapeEstimate{apiCalls: 2, duration: 2 * latency}, true
+	case "escalation":
+		issues := len(module.HTTP.Escalation.Issues)
+		return scrapeEstimate{apiCalls: issues, duration: time.Duration(issues) * latency}, true
+	}
+	return scrapeEstimate{}, false
+}
+
+// printScrapeEstimates discovers the projects of every module and writes the estimated
+// cost of a scrape of each of its probers to w.
+func printScrapeEstimates(conf *Config, w io.Writer) {
+	var names []string
+	for name := range conf.Modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "MODULE\tPROBER\tPROJECTS\tAPI CALLS\tEXPECTED DURATION")
+	for _, name := range names {
+		module := conf.Modules[name]
+		client := clientWithTimeout(url.Values{}, module.HTTP.Lag.Timeout, module.HTTP.transport)
+
+		start := time.Now()
+		discovered, _, err := allSentryProjects(module.HTTP, client)
+		latency := time.Since(start)
+		if err != nil {
+			log.Errorf("Error discovering the projects of module %s: %s", name, err)
+			fmt.Fprintf(tw, "%s\t-\t-\t-\tdiscovery failed\n", name)
+			continue
+		}
+		projects := module.HTTP.Projects.filter(discovered)
+
+		var probers []string
+		for prober := range Probers {
+			if module.allowsProber(prober) && module.supportsProber(prober) {
+				probers = append(probers, prober)
+			}
+		}
+		sort.Strings(probers)
+		for _, prober := range probers {
+			if e, ok := estimateScrape(prober, module, len(projects), latency); ok {
+				fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\n", name, prober, len(projects), e.apiCalls, e.duration.Round(time.Millisecond))
+			}
+		}
+	}
+	tw.Flush()
+}
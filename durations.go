@@ -0,0 +1,142 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+)
+
+var durationType = reflect.TypeOf(model.Duration(0))
+
+// validateDurations checks that every duration of the config file is a
+// valid duration string, naming the module and field of the first invalid
+// one. Bare numbers such as 90 are rejected rather than read as nanoseconds.
+func validateDurations(data []byte) error {
+	var raw interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		// Reported when parsing the config itself.
+		return nil
+	}
+	return checkDurations(raw, reflect.TypeOf(Config{}), nil)
+}
+
+func checkDurations(raw interface{}, t reflect.Type, path []string) error {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == durationType {
+		s, ok := raw.(string)
+		if _, err := model.ParseDuration(s); !ok || err != nil {
+			return durationError(raw, path)
+		}
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		fields, ok := raw.(map[interface{}]interface{})
+		if !ok {
+			return nil
+		}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := strings.Split(f.Tag.Get("yaml"), ",")
+			name := tag[0]
+			if name == "-" || f.PkgPath != "" {
+				continue
+			}
+			if len(tag) > 1 && tag[1] == "inline" {
+				if err := checkDurations(raw, f.Type, path); err != nil {
+					return err
+				}
+				continue
+			}
+			if name == "" {
+				name = strings.ToLower(f.Name)
+			}
+			if v, ok := fields[name]; ok {
+				if err := checkDurations(v, f.Type, append(path, name)); err != nil {
+					return err
+				}
+			}
+		}
+	case reflect.Map:
+		entries, ok := raw.(map[interface{}]interface{})
+		if !ok {
+			return nil
+		}
+		for k, v := range entries {
+			if err := checkDurations(v, t.Elem(), append(path, fmt.Sprint(k))); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		items, ok := raw.([]interface{})
+		if !ok {
+			return nil
+		}
+		for i, v := range items {
+			if err := checkDurations(v, t.Elem(), append(path, fmt.Sprint(i))); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func durationError(raw interface{}, path []string) error {
+	field := strings.Join(path, ".")
+	if len(path) > 2 && path[0] == "modules" {
+		field = fmt.Sprintf("%s of module %s", strings.Join(path[2:], "."), path[1])
+	}
+	return fmt.Errorf("invalid duration %v for %s, durations need a unit, e.g. 90s, 1m30s or 14d", raw, field)
+}
+
+// statsPeriod returns the Sentry stats period of a probe: the period
+// parameter if set, else the configured period, else def.
+func statsPeriod(values url.Values, configured model.Duration, def time.Duration) (string, error) {
+	period := def
+	if configured > 0 {
+		period = time.Duration(configured)
+	}
+	if p := values.Get("period"); p != "" {
+		d, err := model.ParseDuration(p)
+		if err != nil || d <= 0 {
+			return "", fmt.Errorf("invalid period %q, e.g. 24h or 14d", p)
+		}
+		period = time.Duration(d)
+	}
+	return formatStatsPeriod(period), nil
+}
+
+// formatStatsPeriod formats the period with the single unit Sentry expects,
+// e.g. 14d or 24h. Periods of less than 2 days are formatted in hours.
+func formatStatsPeriod(d time.Duration) string {
+	const day = 24 * time.Hour
+	switch {
+	case d >= 2*day && d%day == 0:
+		return fmt.Sprintf("%dd", d/day)
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	}
+	return fmt.Sprintf("%ds", d/time.Second)
+}
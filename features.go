@@ -0,0 +1,73 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Features toggles the optional data collected by a prober, by name.
+// Features not listed keep their default.
+type Features map[string]bool
+
+func (f Features) enabled(name string, def bool) bool {
+	if enabled, ok := f[name]; ok {
+		return enabled
+	}
+	return def
+}
+
+func (f Features) validate(prober string, known ...string) error {
+	var names []string
+	for name := range f {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		found := false
+		for _, k := range known {
+			found = found || k == name
+		}
+		if !found {
+			return fmt.Errorf("unknown %s feature %q", prober, name)
+		}
+	}
+	return nil
+}
+
+// Features of the lag prober.
+const (
+	// The rate limits of the project keys, one more API call per project.
+	lagFeatureRateLimit = "rate_limit"
+	// The rejected events stats, one more API call per project.
+	lagFeatureRejected = "rejected"
+	// The timestamps of the latest stats buckets containing events.
+	lagFeatureLatestTimestamp = "latest_timestamp"
+)
+
+func (l LagOptions) rateLimit() bool {
+	return l.Features.enabled(lagFeatureRateLimit, l.RateLimit)
+}
+
+func (l LagOptions) rejected() bool {
+	return l.Features.enabled(lagFeatureRejected, true)
+}
+
+func (l LagOptions) latestTimestamp() bool {
+	return l.Features.enabled(lagFeatureLatestTimestamp, true)
+}
+
+func (l LagOptions) validate() error {
+	return l.Features.validate("lag", lagFeatureRateLimit, lagFeatureRejected, lagFeatureLatestTimestamp)
+}
@@ -0,0 +1,94 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Flavors of the Sentry API of a module.
+const (
+	flavorSentry = "sentry"
+	// GlitchTip implements most of the Sentry API, but neither the
+	// issues-stats nor the stats_v2 APIs.
+	flavorGlitchTip = "glitchtip"
+)
+
+// glitchTipUnsupported maps the probers which cannot run against GlitchTip
+// to the API they need.
+var glitchTipUnsupported = map[string]string{
+	"outcomes": "stats_v2",
+	"ingest":   "stats_v2",
+}
+
+func (p HTTPProbe) validateFlavor() error {
+	switch p.Flavor {
+	case "", flavorSentry, flavorGlitchTip:
+		return nil
+	}
+	return fmt.Errorf("unknown flavor %q, must be sentry or glitchtip", p.Flavor)
+}
+
+func (p HTTPProbe) glitchTip() bool {
+	return p.Flavor == flavorGlitchTip
+}
+
+// unsupportedAPI returns the API the prober needs which the flavor of the
+// module lacks, empty when the prober can run.
+func (p HTTPProbe) unsupportedAPI(prober string) string {
+	if p.glitchTip() {
+		return glitchTipUnsupported[prober]
+	}
+	return ""
+}
+
+// supportsProber reports whether the flavor of the module provides the APIs
+// of the prober.
+func (m Module) supportsProber(prober string) bool {
+	return m.HTTP.unsupportedAPI(prober) == ""
+}
+
+// flavoredProber returns the prober skipping its probes without requesting
+// the Sentry API when the flavor of the module lacks an API it needs, so
+// that probing several flavors with the same probers doesn't fail.
+func flavoredProber(name string, prober ProbeFn) ProbeFn {
+	return func(values url.Values, registry *prometheus.Registry, module Module) bool {
+		api := module.HTTP.unsupportedAPI(name)
+		if api == "" {
+			return prober(values, registry, module)
+		}
+		module.HTTP.logger().Debugf("Skipping prober %s, the %s API is not supported by %s", name, api, module.HTTP.Flavor)
+		unsupportedGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "sentry_probe_unsupported",
+			Help: "Whether the probe was skipped because the flavor of the Sentry API lacks an API the prober needs",
+		})
+		registry.MustRegister(unsupportedGauge)
+		unsupportedGauge.Set(1)
+		return true
+	}
+}
+
+// listedIssueCounts returns the event counts of the issues as listed, for
+// the Sentry servers without the issues-stats API. Unlike the counts of the
+// issues-stats API, they aren't restricted to the stats period.
+func listedIssueCounts(issues []IssuesResponse) map[string]int {
+	counts := make(map[string]int, len(issues))
+	for _, issue := range issues {
+		counts[issue.Id], _ = strconv.Atoi(issue.Count)
+	}
+	return counts
+}
@@ -3,17 +3,14 @@ module github.com/strike-team/sentry_exporter
 go 1.15
 
 require (
-	github.com/alecthomas/units v0.0.0-20190924025748-f65c72e2690d // indirect
-	github.com/google/go-cmp v0.3.1 // indirect
-	github.com/konsorten/go-windows-terminal-sequences v1.0.2 // indirect
-	github.com/kr/pretty v0.1.0 // indirect
-	github.com/modern-go/concurrent v0.0.0-20180306012644-bacd9c7ef1dd // indirect
-	github.com/modern-go/reflect2 v1.0.1 // indirect
-	github.com/prometheus/client_golang v1.1.0
-	github.com/prometheus/client_model v0.0.0-20190812154241-14fe0d1b01d4 // indirect
-	github.com/prometheus/common v0.7.0
-	github.com/prometheus/procfs v0.0.5 // indirect
-	golang.org/x/sys v0.0.0-20191007092633-5f54ce542709 // indirect
-	gopkg.in/check.v1 v1.0.0-20190902080502-41f04d3bba15 // indirect
-	gopkg.in/yaml.v2 v2.2.4
+	github.com/fsnotify/fsnotify v1.4.9
+	github.com/go-kit/kit v0.10.0
+	github.com/golang/protobuf v1.4.2
+	github.com/prometheus/client_golang v1.7.1
+	github.com/prometheus/client_model v0.2.0
+	github.com/prometheus/common v0.15.0
+	github.com/prometheus/exporter-toolkit v0.5.1
+	golang.org/x/net v0.0.0-20200625001655-4c5254603344
+	gopkg.in/alecthomas/kingpin.v2 v2.2.6
+	gopkg.in/yaml.v2 v2.4.0
 )
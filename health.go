@@ -0,0 +1,124 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// Timeout of the Sentry API request made by the readiness check of a module.
+const readyCheckTimeout = 5 * time.Second
+
+// apiIndexResponse is the index of the Sentry API, whose auth is null when
+// the request was not authenticated.
+type apiIndexResponse struct {
+	Auth *json.RawMessage `json:"auth"`
+}
+
+// checkSentryAPI requests the index of the Sentry API of the module, and
+// checks that it accepted the credentials when the module has some.
+func checkSentryAPI(ctx context.Context, config HTTPProbe) error {
+	ctx, cancel := context.WithTimeout(ctx, readyCheckTimeout)
+	defer cancel()
+	config.ctx = ctx
+	config.apiBudget = nil
+	client := clientWithTimeout(url.Values{}, model.Duration(readyCheckTimeout), config.transport)
+
+	resp, err := requestSentry("", config, client)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if config.bearerToken == "" {
+		return nil
+	}
+	var index apiIndexResponse
+	if err := decodeJSON(resp.Body, &index); err != nil {
+		return err
+	}
+	if index.Auth == nil {
+		return errors.New("the bearer token was not accepted")
+	}
+	return nil
+}
+
+// checkModulesAPI requests the Sentry API of every module concurrently, and
+// returns the failed checks by module.
+func checkModulesAPI(ctx context.Context, conf *Config) map[string]error {
+	var mu sync.Mutex
+	errs := make(map[string]error)
+	var wg sync.WaitGroup
+	for name, module := range conf.Modules {
+		wg.Add(1)
+		go func(name string, config HTTPProbe) {
+			defer wg.Done()
+			if err := checkSentryAPI(ctx, config); err != nil {
+				mu.Lock()
+				errs[name] = err
+				mu.Unlock()
+			}
+		}(name, module.HTTP)
+	}
+	wg.Wait()
+	return errs
+}
+
+// preflight logs the modules whose Sentry API cannot be reached with their
+// credentials, without failing the config load so that an outage of Sentry
+// doesn't prevent reloads.
+func preflight(conf *Config) {
+	for name, err := range checkModulesAPI(context.Background(), conf) {
+		log.Errorf("Preflight request to the Sentry API of module %s at %s failed: %s", name, conf.Modules[name].HTTP.Domain, err)
+	}
+}
+
+// healthyHandler answers as long as the process is up.
+func healthyHandler(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintf(w, "Healthy.\n")
+}
+
+// readyHandler answers once the configuration is loaded. With checkAPI, the
+// Sentry API of every module is also requested concurrently, and the
+// exporter is only ready when all of them answered.
+func readyHandler(w http.ResponseWriter, r *http.Request, conf *Config, checkAPI bool) {
+	if !checkAPI {
+		fmt.Fprintf(w, "Ready.\n")
+		return
+	}
+
+	errs := checkModulesAPI(r.Context(), conf)
+	if len(errs) > 0 {
+		var names []string
+		for name := range errs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		for _, name := range names {
+			log.Errorf("Readiness check of module %s failed: %s", name, errs[name])
+			fmt.Fprintf(w, "Module %s: %s\n", name, errs[name])
+		}
+		return
+	}
+	fmt.Fprintf(w, "Ready.\n")
+}
@@ -0,0 +1,113 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// IssueOwner is an owner of an issue as returned with expand=owners, the
+// owner being an actor such as team:12 or user:34.
+type IssueOwner struct {
+	Type  string `json:"type"`
+	Owner string `json:"owner"`
+}
+
+type IssueAssignee struct {
+	Type string `json:"type"`
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type TeamResponse struct {
+	Id   string `json:"id"`
+	Slug string `json:"slug"`
+}
+
+// owningTeam returns the id of the team owning the issue: the team it is
+// assigned to, or else the first team among its owners, in the order of
+// precedence returned by Sentry. It is empty for issues without an owning
+// team.
+func owningTeam(issue IssuesResponse) string {
+	if a := issue.AssignedTo; a != nil && a.Type == "team" {
+		return a.Id
+	}
+	for _, o := range issue.Owners {
+		if strings.HasPrefix(o.Owner, "team:") {
+			return strings.TrimPrefix(o.Owner, "team:")
+		}
+	}
+	return ""
+}
+
+func extractTeams(reader io.Reader) ([]TeamResponse, error) {
+	var teams []TeamResponse
+	err := decodeJSON(reader, &teams)
+	return teams, err
+}
+
+// sentryTeamSlugs returns the slugs of the teams of the organization by id.
+func sentryTeamSlugs(config HTTPProbe, client *http.Client) (map[string]string, error) {
+	slugs := make(map[string]string)
+	err := requestSentryPages("organizations/"+config.Organization+"/teams/", maxSentryPages, config, client, func(reader io.Reader) error {
+		page, err := extractTeams(reader)
+		if err != nil {
+			return err
+		}
+		for _, t := range page {
+			slugs[t.Id] = t.Slug
+		}
+		return nil
+	})
+	return slugs, err
+}
+
+// registerTeamIssues exports the number of issues above the threshold per
+// owning team. Team ids are resolved to slugs with a single request, and
+// exported as is when that fails.
+func registerTeamIssues(issues map[string]issueCount, thresh int, statsPeriod string, config HTTPProbe, client *http.Client, registry *prometheus.Registry) {
+	teamIssuesGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sentry_team_high_freq_issues",
+		Help: "Number of issues matching the query with more events than the threshold over the period, by owning team",
+	}, []string{"team", "above", "period"})
+	registry.MustRegister(teamIssuesGauge)
+
+	perTeam := make(map[string]int)
+	for _, i := range issues {
+		perTeam[owningTeam(i.issue)]++
+	}
+	if len(perTeam) == 0 {
+		return
+	}
+
+	slugs := make(map[string]string)
+	if _, unowned := perTeam[""]; len(perTeam) > 1 || !unowned {
+		var err error
+		if slugs, err = sentryTeamSlugs(config, client); err != nil {
+			config.logger().Errorf("Exporting team ids, the teams of organization %s could not be listed: %s", config.Organization, err)
+		}
+	}
+
+	above := strconv.Itoa(thresh)
+	for team, count := range perTeam {
+		if slug, ok := slugs[team]; ok {
+			team = slug
+		}
+		teamIssuesGauge.WithLabelValues(team, above, statsPeriod).Set(float64(count))
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+)
+
+var landingTemplate = template.Must(template.New("landing").Parse(`<html>
+<head><title>Sentry Exporter</title></head>
+<body>
+<h1>Sentry Exporter</h1>
+<p><a href="/probe/all">Probe all modules</a></p>
+<p><a href="/metrics">Metrics</a></p>
+<p><a href="/-/selftest">Self-test</a></p>
+<h2>Modules</h2>
+{{range .}}
+<h3>{{.Name}}</h3>
+<ul>
+{{if .Hidden}}<li>Alias of a hidden module</li>{{else}}
+<li>Domain: {{.Domain}}</li>
+<li>Organization: {{.Organization}}</li>
+<li>Credentials: {{.Credentials}}</li>{{end}}
+{{if .Protected}}<li>Probes require a probe token</li>{{end}}
+</ul>
+<p>
+<a href="{{.ProbeURL ""}}">Probe all projects ({{.DefaultProber}})</a>
+| <a href="{{.ProbeURL "all"}}">Probe with all probers</a>
+| <a href="{{.PlanURL}}">Planned API requests</a>
+</p>
+<ul>
+{{$m := .}}{{range .Probers}}<li><a href="{{$m.ProbeURL .}}">{{.}}</a></li>
+{{end}}</ul>
+{{end}}
+</body>
+</html>
+`))
+
+// landingModule is a module as listed on the landing page, without its
+// credentials.
+type landingModule struct {
+	Name          string
+	Hidden        bool
+	Domain        string
+	Organization  string
+	Credentials   string
+	Protected     bool
+	DefaultProber string
+	Probers       []string
+}
+
+func (m landingModule) ProbeURL(prober string) string {
+	params := url.Values{}
+	params.Set("module", m.Name)
+	if prober != "" {
+		params.Set("prober", prober)
+	}
+	return "/probe?" + params.Encode()
+}
+
+func (m landingModule) PlanURL() string {
+	return "/-/plan?" + url.Values{"module": {m.Name}}.Encode()
+}
+
+// credentials describes where the bearer token of the module comes from.
+func (p HTTPProbe) credentials() string {
+	switch {
+	case p.BearerTokenFile != "":
+		return "bearer token read from " + p.BearerTokenFile
+	case p.bearerToken != "":
+		return "bearer token (redacted)"
+	}
+	return "none"
+}
+
+// probers returns the names of the probers the module can run.
+func (m Module) probers() []string {
+	if len(m.EnabledProbers) > 0 {
+		return m.EnabledProbers
+	}
+	var names []string
+	for name := range Probers {
+		// The canary prober cannot run without canary projects.
+		if (name != "canary" || len(m.HTTP.CanaryProjects) > 0) && m.supportsProber(name) {
+			names = append(names, name)
+		}
+	}
+	for name := range m.ExecProbers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// landingHandler lists the probeable modules of the loaded config along with
+// links to their probes. Hidden modules are only listed under their aliases,
+// without their domain and organization.
+func landingHandler(w http.ResponseWriter, r *http.Request, conf *Config) {
+	var modules []landingModule
+	for _, name := range conf.probeableModules() {
+		module, _ := conf.lookupModule(name)
+		modules = append(modules, landingModule{
+			Name:          name,
+			Hidden:        module.Hidden,
+			Domain:        module.HTTP.Domain,
+			Organization:  module.HTTP.Organization,
+			Credentials:   module.HTTP.credentials(),
+			Protected:     len(module.ProbeTokens) > 0,
+			DefaultProber: module.defaultProber(),
+			Probers:       module.probers(),
+		})
+	}
+	if err := landingTemplate.Execute(w, modules); err != nil {
+		log.Errorf("Error rendering the landing page: %s", err)
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/common/promlog"
+)
+
+// Logger logs printf-style messages as structured log lines of a go-kit
+// logger, the formatted message being their msg.
+type Logger struct {
+	kit kitlog.Logger
+	// The go-kit logger filtering the lines by level, before the timestamp
+	// and caller are added.
+	filtered kitlog.Logger
+}
+
+// log is the logger of the exporter, set up from the --log.level and
+// --log.format flags once they are parsed.
+var log = newLogger(os.Stderr, &promlog.Config{})
+
+// newLogger returns a logger writing to w with the level and format of the
+// config, info and logfmt by default, like promlog.New. The caller is that of
+// the Logger method rather than of the go-kit logger.
+func newLogger(w io.Writer, config *promlog.Config) *Logger {
+	return wrapLogger(newFilteredLogger(w, config))
+}
+
+func newFilteredLogger(w io.Writer, config *promlog.Config) kitlog.Logger {
+	var l kitlog.Logger
+	if config.Format != nil && config.Format.String() == "json" {
+		l = kitlog.NewJSONLogger(kitlog.NewSyncWriter(w))
+	} else {
+		l = kitlog.NewLogfmtLogger(kitlog.NewSyncWriter(w))
+	}
+
+	allowed := level.AllowInfo()
+	if config.Level != nil {
+		switch config.Level.String() {
+		case "debug":
+			allowed = level.AllowDebug()
+		case "warn":
+			allowed = level.AllowWarn()
+		case "error":
+			allowed = level.AllowError()
+		}
+	}
+	return level.NewFilter(l, allowed)
+}
+
+// wrapLogger returns the Logger of the filtered go-kit logger, adding the
+// timestamp and caller to its lines.
+func wrapLogger(filtered kitlog.Logger) *Logger {
+	timestamp := kitlog.TimestampFormat(func() time.Time { return time.Now().UTC() }, "2006-01-02T15:04:05.000Z07:00")
+	return &Logger{kit: kitlog.With(filtered, "ts", timestamp, "caller", kitlog.Caller(5)), filtered: filtered}
+}
+
+func (l *Logger) log(lvl func(kitlog.Logger) kitlog.Logger, msg string) {
+	lvl(l.kit).Log("msg", strings.TrimRight(msg, "\n"))
+}
+
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.log(level.Debug, fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.log(level.Info, fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) Infoln(args ...interface{}) {
+	l.log(level.Info, fmt.Sprintln(args...))
+}
+
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.log(level.Warn, fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) Error(args ...interface{}) {
+	l.log(level.Error, fmt.Sprint(args...))
+}
+
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.log(level.Error, fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) Fatal(args ...interface{}) {
+	l.log(level.Error, fmt.Sprint(args...))
+	os.Exit(1)
+}
+
+func (l *Logger) Fatalf(format string, args ...interface{}) {
+	l.log(level.Error, fmt.Sprintf(format, args...))
+	os.Exit(1)
+}
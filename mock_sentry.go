@@ -0,0 +1,199 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Projects returned per page of the mock organization's projects list.
+const mockProjectsPerPage = 100
+
+// mockSentry is an in-process Sentry API serving an organization with the
+// given number of projects, answering every request after the given
+// latency. It implements the endpoints used by the built-in probers.
+type mockSentry struct {
+	server   *httptest.Server
+	projects int
+	latency  time.Duration
+	requests int64
+}
+
+func newMockSentry(projects int, latency time.Duration) *mockSentry {
+	m := &mockSentry{projects: projects, latency: latency}
+	m.server = httptest.NewServer(http.HandlerFunc(m.serveHTTP))
+	return m
+}
+
+func (m *mockSentry) URL() string {
+	return m.server.URL
+}
+
+func (m *mockSentry) Close() {
+	m.server.Close()
+}
+
+// resetRequests returns the number of requests served since the last reset.
+func (m *mockSentry) resetRequests() int {
+	return int(atomic.SwapInt64(&m.requests, 0))
+}
+
+func (m *mockSentry) projectSlug(i int) string {
+	return fmt.Sprintf("project-%d", i)
+}
+
+func (m *mockSentry) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt64(&m.requests, 1)
+	select {
+	case <-time.After(m.latency):
+	case <-r.Context().Done():
+		return
+	}
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/0/"), "/")
+	parts := strings.Split(path, "/")
+	now := time.Now()
+
+	var body interface{}
+	switch {
+	case len(parts) == 3 && parts[0] == "organizations" && parts[2] == "projects":
+		body = m.projectsPage(w, r)
+	case len(parts) == 4 && parts[0] == "organizations" && parts[2] == "issues":
+		body = map[string]interface{}{
+			"id":        parts[3],
+			"substatus": "ongoing",
+			"project":   map[string]string{"id": "1", "slug": m.projectSlug(1)},
+			"stats": map[string][][]int{
+				"24h": mockStats(now, time.Hour, 24),
+				"30d": mockStats(now, 24*time.Hour, 30),
+			},
+		}
+	case len(parts) == 3 && parts[0] == "organizations" && parts[2] == "issues":
+		var issues []map[string]interface{}
+		for i := 1; i <= 25 && i <= m.projects; i++ {
+			issues = append(issues, map[string]interface{}{
+				"id":      strconv.Itoa(i),
+				"title":   fmt.Sprintf("Error %d", i),
+				"culprit": "main",
+				"count":   "20000",
+				"project": map[string]string{"id": strconv.Itoa(i), "slug": m.projectSlug(i)},
+				"owners":  []map[string]string{{"type": "ownershipRule", "owner": "team:1"}},
+			})
+		}
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"; results="false"; cursor="0"`, r.URL.Path))
+		body = issues
+	case len(parts) == 3 && parts[0] == "organizations" && parts[2] == "issues-count":
+		counts := make(map[string]int)
+		for i, q := range r.URL.Query()["query"] {
+			counts[q] = 10 * (i + 1)
+		}
+		body = counts
+	case len(parts) == 3 && parts[0] == "organizations" && parts[2] == "issues-stats":
+		var stats []map[string]string
+		for _, id := range r.URL.Query()["groups"] {
+			stats = append(stats, map[string]string{"id": id, "count": "20000"})
+		}
+		body = stats
+	case len(parts) == 3 && parts[0] == "organizations" && parts[2] == "releases":
+		body = []map[string]interface{}{{"version": "1.0.0", "dateCreated": now.Add(-24 * time.Hour)}}
+	case len(parts) == 5 && parts[0] == "organizations" && parts[2] == "releases" && parts[4] == "deploys":
+		body = []map[string]string{{"environment": "production", "dateFinished": now.Format(time.RFC3339)}}
+	case len(parts) == 3 && parts[0] == "organizations" && (parts[2] == "sessions" || parts[2] == "stats_v2"):
+		var groups []map[string]interface{}
+		for i := 1; i <= m.projects; i++ {
+			groups = append(groups, map[string]interface{}{
+				"by": map[string]interface{}{"project": i, "release": "1.0.0", "outcome": "accepted", "category": "error"},
+				"totals": map[string]interface{}{
+					"sum(session)":             100,
+					"count_unique(user)":       10,
+					"crash_free_rate(session)": 0.99,
+					"crash_free_rate(user)":    0.9,
+					"sum(quantity)":            100,
+				},
+			})
+		}
+		body = map[string]interface{}{"groups": groups}
+	case len(parts) == 3 && parts[0] == "organizations" && parts[2] == "teams":
+		body = []map[string]string{{"id": "1", "slug": "backend"}}
+	case len(parts) == 3 && parts[0] == "organizations" && parts[2] == "monitors":
+		body = []map[string]interface{}{{"slug": "nightly", "status": "ok", "lastCheckIn": now.Add(-time.Hour), "nextCheckIn": now.Add(23 * time.Hour)}}
+	case len(parts) == 3 && parts[0] == "organizations" && parts[2] == "alert-rules":
+		body = []map[string]interface{}{{"id": "1", "name": "High error rate", "projects": []string{m.projectSlug(1)}}}
+	case len(parts) == 3 && parts[0] == "organizations" && parts[2] == "incidents":
+		body = []map[string]interface{}{}
+	case len(parts) == 4 && parts[0] == "projects" && parts[3] == "stats":
+		body = mockStats(now, 10*time.Second, 360)
+	case len(parts) == 4 && parts[0] == "projects" && parts[3] == "keys":
+		body = []map[string]interface{}{{
+			"id": "1", "name": "Default", "label": "Default", "isActive": true,
+			"rateLimit": map[string]int{"window": 60, "count": 1000},
+		}}
+	case len(parts) == 6 && parts[0] == "projects" && parts[3] == "keys" && parts[5] == "stats":
+		var stats []map[string]int64
+		for _, s := range mockStats(now, 10*time.Second, 360) {
+			stats = append(stats, map[string]int64{"ts": int64(s[0]), "total": int64(s[1]), "accepted": int64(s[1])})
+		}
+		body = stats
+	case len(parts) == 4 && parts[0] == "projects" && parts[3] == "events":
+		body = []map[string]interface{}{{"eventID": "1", "dateCreated": now.Add(-time.Minute)}}
+	case len(parts) == 4 && parts[0] == "projects" && parts[3] == "environments":
+		body = []map[string]string{{"name": "production"}}
+	case len(parts) == 4 && parts[0] == "projects" && parts[3] == "processing-issues":
+		body = map[string]interface{}{"hasIssues": false, "numIssues": 0, "resolveableIssues": 0, "issuesProcessing": 0}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
+
+// projectsPage returns the page of projects at the request's cursor, setting
+// the Link header to the next one.
+func (m *mockSentry) projectsPage(w http.ResponseWriter, r *http.Request) []map[string]interface{} {
+	start, _ := strconv.Atoi(r.URL.Query().Get("cursor"))
+	end := start + mockProjectsPerPage
+	if end > m.projects {
+		end = m.projects
+	}
+	more := end < m.projects
+	w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"; results="%t"; cursor="%d"`, r.URL.Path, more, end))
+
+	projects := []map[string]interface{}{}
+	for i := start + 1; i <= end; i++ {
+		projects = append(projects, map[string]interface{}{
+			"id":    strconv.Itoa(i),
+			"slug":  m.projectSlug(i),
+			"teams": []map[string]string{{"id": "1", "slug": "backend"}},
+		})
+	}
+	return projects
+}
+
+// mockStats returns count buckets of the given resolution up to now, with a
+// single event each.
+func mockStats(now time.Time, resolution time.Duration, count int) [][]int {
+	stats := make([][]int, count)
+	for i := range stats {
+		stats[i] = []int{int(now.Add(-time.Duration(count-i) * resolution).Unix()), 1}
+	}
+	return stats
+}
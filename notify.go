@@ -0,0 +1,169 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// AlertOptions makes the background collection of a module send alerts to
+// an Alertmanager when the collected series breach their thresholds.
+type AlertOptions struct {
+	// Alertmanager alerts API, e.g. http://alertmanager:9093/api/v2/alerts.
+	URL        string           `yaml:"url"`
+	Thresholds []AlertThreshold `yaml:"thresholds"`
+}
+
+// AlertThreshold fires the alert for every series selected by the metric
+// and labels whose value is above Above.
+type AlertThreshold struct {
+	Alert          string `yaml:"alert"`
+	MetricSelector `yaml:",inline"`
+	Above          float64           `yaml:"above"`
+	Annotations    map[string]string `yaml:"annotations"`
+}
+
+func (a AlertOptions) validate(collect CollectOptions) error {
+	if len(a.Thresholds) == 0 {
+		return nil
+	}
+	if a.URL == "" {
+		return fmt.Errorf("alerts require a url")
+	}
+	if collect.Interval <= 0 {
+		return fmt.Errorf("alerts require background collection")
+	}
+	for _, t := range a.Thresholds {
+		if t.Alert == "" || t.Metric == "" {
+			return fmt.Errorf("alert thresholds require an alert name and a metric")
+		}
+	}
+	return nil
+}
+
+type alertmanagerAlert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	StartsAt    time.Time         `json:"startsAt"`
+	EndsAt      time.Time         `json:"endsAt"`
+}
+
+// alertNotifier sends the alerts of the background collection, remembering
+// the firing ones so that they are resolved once their series recover.
+type alertNotifier struct {
+	mu     sync.Mutex
+	firing map[collectorKey]map[string]alertmanagerAlert
+	client *http.Client
+}
+
+func newAlertNotifier() *alertNotifier {
+	return &alertNotifier{
+		firing: make(map[collectorKey]map[string]alertmanagerAlert),
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// evaluateThresholds returns the alerts firing for the collected families,
+// keyed by their labels.
+func evaluateThresholds(key collectorKey, thresholds []AlertThreshold, families []*dto.MetricFamily) map[string]alertmanagerAlert {
+	byName := make(map[string]*dto.MetricFamily)
+	for _, mf := range families {
+		byName[mf.GetName()] = mf
+	}
+
+	alerts := make(map[string]alertmanagerAlert)
+	for _, t := range thresholds {
+		for _, s := range selectSamples(byName, t.MetricSelector) {
+			if s.value <= t.Above {
+				continue
+			}
+			labels := map[string]string{
+				"alertname": t.Alert,
+				"module":    key.module,
+				"prober":    key.prober,
+			}
+			for name, value := range t.Labels {
+				labels[name] = value
+			}
+			for name, value := range s.labels {
+				labels[name] = value
+			}
+			annotations := map[string]string{
+				"value": strconv.FormatFloat(s.value, 'g', -1, 64),
+			}
+			for name, value := range t.Annotations {
+				annotations[name] = value
+			}
+			alerts[labelsKey(labels)] = alertmanagerAlert{Labels: labels, Annotations: annotations}
+		}
+	}
+	return alerts
+}
+
+// notify sends the alerts firing for the collected families, valid until
+// the results expire, along with the previously firing alerts which are now
+// resolved.
+func (n *alertNotifier) notify(key collectorKey, module Module, families []*dto.MetricFamily) {
+	if len(module.Alerts.Thresholds) == 0 {
+		return
+	}
+	now := time.Now()
+	firing := evaluateThresholds(key, module.Alerts.Thresholds, families)
+
+	n.mu.Lock()
+	previous := n.firing[key]
+	var alerts []alertmanagerAlert
+	for k, a := range firing {
+		a.StartsAt = now
+		if p, ok := previous[k]; ok {
+			a.StartsAt = p.StartsAt
+		}
+		a.EndsAt = now.Add(module.Collect.ttl())
+		firing[k] = a
+		alerts = append(alerts, a)
+	}
+	for k, p := range previous {
+		if _, ok := firing[k]; !ok {
+			p.EndsAt = now
+			alerts = append(alerts, p)
+		}
+	}
+	n.firing[key] = firing
+	n.mu.Unlock()
+
+	if len(alerts) == 0 {
+		return
+	}
+	body, err := json.Marshal(alerts)
+	if err != nil {
+		log.Errorf("Error encoding alerts of module %s: %s", key.module, err)
+		return
+	}
+	resp, err := n.client.Post(module.Alerts.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Errorf("Error sending alerts of module %s: %s", key.module, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		log.Errorf("Error sending alerts of module %s: Alertmanager returned %d", key.module, resp.StatusCode)
+	}
+}
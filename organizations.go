@@ -0,0 +1,100 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"net/url"
+	"sort"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// organizations returns every organization probed by the module: Organization
+// followed by the additional Organizations, sorted.
+func (p HTTPProbe) organizations() []string {
+	var orgs []string
+	for org := range p.Organizations {
+		if org != p.Organization {
+			orgs = append(orgs, org)
+		}
+	}
+	sort.Strings(orgs)
+	if p.Organization != "" {
+		orgs = append([]string{p.Organization}, orgs...)
+	}
+	return orgs
+}
+
+// forOrganization returns the configuration to probe the given organization,
+// with the organization's own bearer token if it has one.
+func (p HTTPProbe) forOrganization(org string) HTTPProbe {
+	p.Organization = org
+	p.Organizations = nil
+	if token := p.organizationTokens[org]; token != "" {
+		p.bearerToken = token
+	}
+	return p
+}
+
+// probeOrganizations runs the prober against every organization of the
+// module concurrently and merges their metrics with an organization label.
+// The probe succeeds if it succeeded for every organization.
+func probeOrganizations(prober ProbeFn) ProbeFn {
+	return func(values url.Values, registry *prometheus.Registry, module Module) bool {
+		orgs := module.HTTP.organizations()
+		registries := make([]*prometheus.Registry, len(orgs))
+		successes := make([]bool, len(orgs))
+
+		var wg sync.WaitGroup
+		for i, org := range orgs {
+			wg.Add(1)
+			go func(i int, org string) {
+				defer wg.Done()
+				orgModule := module
+				orgModule.HTTP = module.HTTP.forOrganization(org)
+				registries[i] = prometheus.NewRegistry()
+				successes[i] = prober(values, registries[i], orgModule)
+			}(i, org)
+		}
+		wg.Wait()
+
+		success := true
+		merged := make(familiesCollector)
+		for i, org := range orgs {
+			success = success && successes[i]
+			families, err := registries[i].Gather()
+			if err != nil {
+				module.HTTP.logger().Errorf("Error gathering metrics of organization %s: %s", org, err)
+				success = false
+				continue
+			}
+			for _, mf := range families {
+				out, ok := merged[mf.GetName()]
+				if !ok {
+					out = &dto.MetricFamily{Name: mf.Name, Help: mf.Help, Type: mf.Type}
+					merged[mf.GetName()] = out
+				}
+				for _, m := range mf.Metric {
+					out.Metric = append(out.Metric, withLabels(m, map[string]string{"organization": org}))
+				}
+			}
+		}
+		if err := registry.Register(merged); err != nil {
+			module.HTTP.logger().Errorf("Error registering metrics of organizations: %s", err)
+			return false
+		}
+		return success
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// validatedRegistry gathers the registry of a probe into a new registry which
+// can always be served. Series Prometheus would reject, such as a series
+// duplicating the name and labels of another one, are dropped and counted in
+// sentry_probe_dropped_series instead of failing the whole response.
+func validatedRegistry(registry *prometheus.Registry, logger *Logger) *prometheus.Registry {
+	families, err := registry.Gather()
+
+	dropped := 0
+	if errs, ok := err.(prometheus.MultiError); ok {
+		for _, e := range errs {
+			logger.Errorf("Dropping series from the probe output: %s", e)
+		}
+		dropped = len(errs)
+	} else if err != nil {
+		logger.Errorf("Dropping series from the probe output: %s", err)
+		dropped = 1
+	}
+
+	gathered := make(familiesCollector)
+	for _, mf := range families {
+		gathered[mf.GetName()] = mf
+	}
+	droppedGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "sentry_probe_dropped_series",
+		Help: "Number of series dropped from the probe output because they duplicated another series or were invalid",
+	})
+	droppedGauge.Set(float64(dropped))
+
+	validated := prometheus.NewRegistry()
+	validated.MustRegister(gathered, droppedGauge)
+	return validated
+}
@@ -0,0 +1,231 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+)
+
+// PacingOptions slows down the requests of a module to the endpoints whose
+// rate limit is nearly used up, according to the X-Sentry-Rate-Limit
+// headers of the Sentry API, so that the exporter doesn't get the token
+// throttled for the other consumers of the API.
+type PacingOptions struct {
+	// Share of the rate limit of an endpoint left below which its requests
+	// are spread until the limit resets, e.g. 0.2. Defaults to no pacing.
+	MinRemaining float64 `yaml:"min_remaining"`
+	// Longest delay of a single request. Defaults to 10s.
+	MaxDelay model.Duration `yaml:"max_delay"`
+	// Probers not run at all while the rate limit of an endpoint is below
+	// min_remaining, e.g. the least important ones.
+	SkipProbers []string `yaml:"skip_probers"`
+}
+
+// Rate limit headers of the responses of the Sentry API.
+const (
+	rateLimitLimitHeader     = "X-Sentry-Rate-Limit-Limit"
+	rateLimitRemainingHeader = "X-Sentry-Rate-Limit-Remaining"
+	rateLimitResetHeader     = "X-Sentry-Rate-Limit-Reset"
+)
+
+var (
+	apiQuotaRemaining = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sentry_exporter_api_quota_remaining_ratio",
+		Help: "Share of the rate limit of the endpoint left according to the latest response of the Sentry API",
+	}, []string{"module", "endpoint"})
+	pacedRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "sentry_exporter_paced_requests_total",
+		Help: "Number of requests to the Sentry API delayed because the rate limit of their endpoint was nearly used up",
+	}, []string{"module", "endpoint"})
+	pacedProbesSkippedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "sentry_exporter_paced_probes_skipped_total",
+		Help: "Number of probes not run because the rate limit of an endpoint was nearly used up",
+	}, []string{"module", "prober"})
+)
+
+func init() {
+	prometheus.MustRegister(apiQuotaRemaining, pacedRequestsTotal, pacedProbesSkippedTotal)
+}
+
+func (o PacingOptions) maxDelay() time.Duration {
+	if o.MaxDelay > 0 {
+		return time.Duration(o.MaxDelay)
+	}
+	return 10 * time.Second
+}
+
+func (o PacingOptions) validate() error {
+	if o.MinRemaining < 0 || o.MinRemaining >= 1 {
+		return fmt.Errorf("pacing min_remaining must be between 0 and 1, got %g", o.MinRemaining)
+	}
+	return nil
+}
+
+// skips reports whether the prober is not run while the quota is low.
+func (o PacingOptions) skips(prober string) bool {
+	for _, p := range o.SkipProbers {
+		if p == prober {
+			return true
+		}
+	}
+	return false
+}
+
+// endpointQuota is the rate limit of an endpoint as of the latest response.
+type endpointQuota struct {
+	limit     int
+	remaining int
+	reset     time.Time
+}
+
+// low reports whether less than min of the rate limit is left until it
+// resets.
+func (q endpointQuota) low(min float64, now time.Time) bool {
+	return q.limit > 0 && now.Before(q.reset) && float64(q.remaining) < min*float64(q.limit)
+}
+
+// apiQuota tracks the rate limits of the endpoints requested by the probes
+// of a module, shared until the next config reload.
+type apiQuota struct {
+	module  string
+	options PacingOptions
+
+	mu        sync.Mutex
+	endpoints map[string]endpointQuota
+}
+
+// newAPIQuota returns the quota of the module, nil without pacing.
+func newAPIQuota(module string, options PacingOptions) *apiQuota {
+	if options.MinRemaining <= 0 {
+		return nil
+	}
+	return &apiQuota{
+		module:    module,
+		options:   options,
+		endpoints: make(map[string]endpointQuota),
+	}
+}
+
+// observe records the rate limit of the endpoint sent with the response,
+// if any.
+func (q *apiQuota) observe(endpoint string, resp *http.Response) {
+	if q == nil {
+		return
+	}
+	limit, err := strconv.Atoi(resp.Header.Get(rateLimitLimitHeader))
+	if err != nil || limit <= 0 {
+		return
+	}
+	remaining, err := strconv.Atoi(resp.Header.Get(rateLimitRemainingHeader))
+	if err != nil {
+		return
+	}
+	reset, err := strconv.ParseInt(resp.Header.Get(rateLimitResetHeader), 10, 64)
+	if err != nil {
+		return
+	}
+	q.mu.Lock()
+	q.endpoints[endpoint] = endpointQuota{limit: limit, remaining: remaining, reset: time.Unix(reset, 0)}
+	q.mu.Unlock()
+	apiQuotaRemaining.WithLabelValues(q.module, endpoint).Set(float64(remaining) / float64(limit))
+}
+
+// delay returns how long to wait before requesting the endpoint, so that
+// its remaining requests are spread until its rate limit resets.
+func (q *apiQuota) delay(endpoint string) time.Duration {
+	if q == nil {
+		return 0
+	}
+	q.mu.Lock()
+	quota, ok := q.endpoints[endpoint]
+	q.mu.Unlock()
+	now := time.Now()
+	if !ok || !quota.low(q.options.MinRemaining, now) {
+		return 0
+	}
+	remaining := quota.remaining
+	if remaining < 0 {
+		remaining = 0
+	}
+	d := quota.reset.Sub(now) / time.Duration(remaining+1)
+	if max := q.options.maxDelay(); d > max {
+		d = max
+	}
+	return d
+}
+
+// pace waits before a request to the endpoint while its quota is low.
+func (q *apiQuota) pace(ctx context.Context, endpoint string, logger *Logger) error {
+	d := q.delay(endpoint)
+	if d <= 0 {
+		return nil
+	}
+	logger.Debugf("Rate limit of %s nearly used up, delaying the request by %s", endpoint, d)
+	pacedRequestsTotal.WithLabelValues(q.module, endpoint).Inc()
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// low returns an endpoint whose quota is low, empty if there is none.
+func (q *apiQuota) low() string {
+	if q == nil {
+		return ""
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	for endpoint, quota := range q.endpoints {
+		if quota.low(q.options.MinRemaining, now) {
+			return endpoint
+		}
+	}
+	return ""
+}
+
+// pacedProber returns the prober skipping its probes while the quota of the
+// module is low, when it is one of the skip_probers.
+func pacedProber(name string, prober ProbeFn, options PacingOptions) ProbeFn {
+	if !options.skips(name) {
+		return prober
+	}
+	return func(values url.Values, registry *prometheus.Registry, module Module) bool {
+		endpoint := module.HTTP.quota.low()
+		if endpoint == "" {
+			return prober(values, registry, module)
+		}
+		module.HTTP.logger().Warnf("Rate limit of %s nearly used up, skipping prober %s", endpoint, name)
+		pacedProbesSkippedTotal.WithLabelValues(module.HTTP.quota.module, name).Inc()
+		skippedGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "sentry_probe_skipped_low_quota",
+			Help: "Whether the probe was skipped because the rate limit of an endpoint of the Sentry API was nearly used up",
+		})
+		registry.MustRegister(skippedGauge)
+		skippedGauge.Set(1)
+		return false
+	}
+}
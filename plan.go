@@ -0,0 +1,111 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/promlog"
+)
+
+// apiPlan records the requests a probe would make to the Sentry API instead
+// of making them.
+type apiPlan struct {
+	mu   sync.Mutex
+	urls []string
+}
+
+// record adds the request to the plan and returns the response it is
+// answered with: a null body, which decodes to an empty value, so that the
+// probe goes on without data.
+func (p *apiPlan) record(requestURL string) *http.Response {
+	p.mu.Lock()
+	p.urls = append(p.urls, requestURL)
+	p.mu.Unlock()
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(strings.NewReader("null")),
+	}
+}
+
+// planHandler answers with the URLs of the Sentry API the next probe of the
+// module would request, with the same parameters as /probe, without
+// requesting them. The projects are those of the project cache and filters,
+// and requests which depend on the responses of earlier ones, such as the
+// further pages of a list, are not planned.
+func planHandler(w http.ResponseWriter, r *http.Request, conf *Config) {
+	params := r.URL.Query()
+
+	moduleName := params.Get("module")
+	if moduleName == "" {
+		moduleName = "sentry"
+	}
+	module, ok := conf.lookupModule(moduleName)
+	if !ok {
+		http.Error(w, fmt.Sprintf("Unknown module %q", moduleName), 400)
+		return
+	}
+	if !module.authorizesProbe(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, fmt.Sprintf("A valid probe token is required for module %q", moduleName), http.StatusUnauthorized)
+		return
+	}
+	if org := params.Get("organization"); org != "" {
+		if org != module.HTTP.Organization && !module.HTTP.allowsOrganization(org) {
+			http.Error(w, fmt.Sprintf("Organization %q is not allowed for module %q", org, moduleName), 400)
+			return
+		}
+		module.HTTP = module.HTTP.forOrganization(org)
+	}
+	proberName := params.Get("prober")
+	if proberName == "" {
+		proberName = module.defaultProber()
+	}
+	prober, ok := Probers[proberName]
+	if _, exec := module.ExecProbers[proberName]; exec {
+		http.Error(w, fmt.Sprintf("Exec prober %q cannot be planned", proberName), 400)
+		return
+	}
+	if !ok {
+		http.Error(w, fmt.Sprintf("Unknown prober %q", proberName), 400)
+		return
+	}
+	if !module.allowsProber(proberName) {
+		http.Error(w, fmt.Sprintf("Prober %q is not enabled for module %q", proberName, moduleName), 400)
+		return
+	}
+
+	plan := &apiPlan{}
+	module.HTTP.ctx = r.Context()
+	module.HTTP.plan = plan
+	module.HTTP.probeLogger = newLogger(ioutil.Discard, &promlog.Config{})
+	module.HTTP = module.HTTP.withOverrides(params)
+	if len(module.HTTP.Organizations) > 0 {
+		prober = probeOrganizations(prober)
+	}
+	prober(params, prometheus.NewRegistry(), module)
+
+	urls := append([]string{}, plan.urls...)
+	sort.Strings(urls)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, u := range urls {
+		fmt.Fprintf(w, "GET %s\n", u)
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+// projectHealth is the outcome of the latest lag probe of a project.
+type projectHealth struct {
+	failed bool
+	lag    int64
+	events int
+}
+
+// healthHistory remembers the health of every probed project, so that
+// probes which may not reach every project fetch the most important ones
+// first.
+type healthHistory struct {
+	mu      sync.Mutex
+	healths map[projectKey]projectHealth
+}
+
+var projectHistory = &healthHistory{healths: make(map[projectKey]projectHealth)}
+
+func (h *healthHistory) record(org, project string, health projectHealth) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.healths[projectKey{org, project}] = health
+}
+
+// prioritize returns the projects ordered by importance: those which failed
+// last time, then those never probed, then by decreasing lag and event
+// volume.
+func (h *healthHistory) prioritize(org string, projects []string) []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	rank := func(project string) int {
+		health, ok := h.healths[projectKey{org, project}]
+		switch {
+		case ok && health.failed:
+			return 0
+		case !ok:
+			return 1
+		}
+		return 2
+	}
+
+	ordered := append([]string(nil), projects...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		ri, rj := rank(ordered[i]), rank(ordered[j])
+		if ri != rj || ri != 2 {
+			return ri < rj
+		}
+		hi := h.healths[projectKey{org, ordered[i]}]
+		hj := h.healths[projectKey{org, ordered[j]}]
+		if hi.lag != hj.lag {
+			return hi.lag > hj.lag
+		}
+		return hi.events > hj.events
+	})
+	return ordered
+}
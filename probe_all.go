@@ -0,0 +1,92 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// probeAllHandler runs the default prober of every module the request may
+// probe concurrently, and returns their metrics with a module label.
+func probeAllHandler(w http.ResponseWriter, r *http.Request, conf *Config, timeoutOffset time.Duration) {
+	params := r.URL.Query()
+	params.Del("module")
+	params.Del("prober")
+	params.Del("organization")
+
+	ctx := r.Context()
+	if timeout, ok := scrapeTimeout(r, timeoutOffset); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	names := conf.probeableModules()
+	registries := make([]*prometheus.Registry, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		module, _ := conf.lookupModule(name)
+		if !module.authorizesProbe(r) {
+			log.Debugf("Skipping module %s, the request has none of its probe tokens", name)
+			continue
+		}
+		proberName := module.defaultProber()
+		prober, ok := module.lookupProber(proberName)
+		if !ok || !module.allowsProber(proberName) {
+			log.Warnf("Default prober %q of module %s is not enabled, skipping it", proberName, name)
+			continue
+		}
+		module.HTTP.ctx = ctx
+
+		wg.Add(1)
+		go func(i int, name string, prober ProbeFn, module Module) {
+			defer wg.Done()
+			registries[i] = runProbe(name, prober, params, module)
+		}(i, name, prober, module)
+	}
+	wg.Wait()
+
+	merged := make(familiesCollector)
+	for i, name := range names {
+		if registries[i] == nil {
+			continue
+		}
+		families, err := registries[i].Gather()
+		if err != nil {
+			log.Errorf("Error gathering metrics of module %s: %s", name, err)
+			continue
+		}
+		for _, mf := range families {
+			out, ok := merged[mf.GetName()]
+			if !ok {
+				out = &dto.MetricFamily{Name: mf.Name, Help: mf.Help, Type: mf.Type}
+				merged[mf.GetName()] = out
+			}
+			for _, m := range mf.Metric {
+				out.Metric = append(out.Metric, withLabels(m, map[string]string{"module": name}))
+			}
+		}
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(merged)
+	h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+	h.ServeHTTP(w, r)
+}
@@ -0,0 +1,42 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// authorizesProbe returns whether the request may probe the module: modules
+// without probe tokens can be probed by anyone, the others require one of
+// their tokens as a bearer token. Tokens empty once expanded never match, so
+// that an unset environment variable doesn't open the module.
+func (m Module) authorizesProbe(r *http.Request) bool {
+	if len(m.ProbeTokens) == 0 {
+		return true
+	}
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	given := []byte(strings.TrimPrefix(auth, "Bearer "))
+	for _, token := range m.ProbeTokens {
+		token = os.ExpandEnv(token)
+		if token != "" && subtle.ConstantTimeCompare(given, []byte(token)) == 1 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// allProbers is the prober parameter running the enabled probers of the
+// module in a single probe, or the lag and issues probers when the module
+// doesn't restrict them.
+const allProbers = "all"
+
+// proberNames returns the probers run by a probe with the given prober
+// parameter, a single prober, a comma-separated list of probers or all.
+func (m Module) proberNames(param string) []string {
+	if param == allProbers {
+		if len(m.EnabledProbers) > 0 {
+			return m.EnabledProbers
+		}
+		return []string{"lag", "issues"}
+	}
+	var names []string
+	for _, name := range strings.Split(param, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// multiProber returns the prober running the probers concurrently and
+// merging their metrics, with a prober label so that the metrics every
+// prober exports, such as sentry_fetch_failures, don't collide. The probe
+// succeeds when all of them do, the success of each being exported as
+// sentry_prober_success{prober}.
+func multiProber(names []string, probers []ProbeFn) ProbeFn {
+	return func(values url.Values, registry *prometheus.Registry, module Module) bool {
+		registries := make([]*prometheus.Registry, len(probers))
+		successes := make([]bool, len(probers))
+		var wg sync.WaitGroup
+		for i, prober := range probers {
+			wg.Add(1)
+			go func(i int, prober ProbeFn) {
+				defer wg.Done()
+				registries[i] = prometheus.NewRegistry()
+				successes[i] = prober(values, registries[i], module)
+			}(i, prober)
+		}
+		wg.Wait()
+
+		proberSuccessGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "sentry_prober_success",
+			Help: "Whether the prober run along with the others of the probe succeeded",
+		}, []string{"prober"})
+		registry.MustRegister(proberSuccessGauge)
+
+		success := true
+		merged := make(familiesCollector)
+		for i, name := range names {
+			if successes[i] {
+				proberSuccessGauge.WithLabelValues(name).Set(1)
+			} else {
+				proberSuccessGauge.WithLabelValues(name).Set(0)
+				success = false
+			}
+			families, err := registries[i].Gather()
+			if err != nil {
+				module.HTTP.logger().Errorf("Error gathering metrics of prober %s: %s", name, err)
+				success = false
+				continue
+			}
+			for _, mf := range families {
+				out, ok := merged[mf.GetName()]
+				if !ok {
+					out = &dto.MetricFamily{Name: mf.Name, Help: mf.Help, Type: mf.Type}
+					merged[mf.GetName()] = out
+				}
+				for _, m := range mf.Metric {
+					out.Metric = append(out.Metric, withLabels(m, map[string]string{"prober": name}))
+				}
+			}
+		}
+		registry.MustRegister(merged)
+		return success
+	}
+}
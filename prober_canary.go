@@ -0,0 +1,52 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"net/url"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// canaryLagOptions returns the lag options of the canary prober, collecting
+// every optional data and fetching every canary project.
+func canaryLagOptions(l LagOptions) LagOptions {
+	l.Features = Features{
+		lagFeatureRateLimit:       true,
+		lagFeatureRejected:        true,
+		lagFeatureLatestTimestamp: true,
+	}
+	l.SoftDeadline = 0
+	return l
+}
+
+// probeHTTPCanary runs the lag probe with full detail against the canary
+// projects of the module only, without discovering the projects of the
+// organization, so that it is cheap enough to be scraped every few seconds.
+func probeHTTPCanary(values url.Values, registry *prometheus.Registry, module Module) bool {
+	config := module.HTTP
+	if len(config.CanaryProjects) == 0 {
+		config.logger().Errorf("The canary prober requires canary_projects")
+		return false
+	}
+	config.pinnedTargets = config.CanaryProjects
+	config.Lag = canaryLagOptions(config.Lag)
+	module.HTTP = config
+
+	params := url.Values{}
+	for key, value := range values {
+		params[key] = value
+	}
+	params.Del("target")
+	return probeHTTPLag(params, registry, module)
+}
@@ -0,0 +1,122 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type IssueDetailsResponse struct {
+	Id        string             `json:"id"`
+	Substatus string             `json:"substatus"`
+	Project   IssuesProject      `json:"project"`
+	Stats     map[string][][]int `json:"stats"`
+}
+
+func extractIssueDetails(reader io.Reader) (IssueDetailsResponse, error) {
+	var issue IssueDetailsResponse
+	err := decodeJSON(reader, &issue)
+	return issue, err
+}
+
+func sumStats(stats [][]int) int {
+	total := 0
+	for _, s := range stats {
+		if len(s) > 1 {
+			total += s[1]
+		}
+	}
+	return total
+}
+
+type escalationMetrics struct {
+	escalating *prometheus.GaugeVec
+	events24h  *prometheus.GaugeVec
+	baseline   *prometheus.GaugeVec
+}
+
+func requestIssueEscalation(id string, config HTTPProbe, client *http.Client, metrics *escalationMetrics) error {
+	resp, err := requestSentry("organizations/"+config.Organization+"/issues/"+id+"/", config, client)
+	if err != nil {
+		config.logger().Error(err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	issue, err := extractIssueDetails(resp.Body)
+	if err != nil {
+		config.logger().Error(err)
+		return err
+	}
+
+	escalating := 0.0
+	if issue.Substatus == "escalating" {
+		escalating = 1
+	}
+	metrics.escalating.WithLabelValues(id, issue.Project.Slug).Set(escalating)
+	if stats, ok := issue.Stats["24h"]; ok {
+		metrics.events24h.WithLabelValues(id, issue.Project.Slug).Set(float64(sumStats(stats)))
+	}
+	if stats, ok := issue.Stats["30d"]; ok && len(stats) > 0 {
+		metrics.baseline.WithLabelValues(id, issue.Project.Slug).Set(float64(sumStats(stats)) / float64(len(stats)))
+	}
+	return nil
+}
+
+// probeHTTPEscalation records Prometheus metrics comparing the current event rate of
+// the watched issues with their daily baseline, along with whether Sentry flagged
+// them as escalating. Sentry does not expose its escalation forecasts through the
+// API, so the 30 day daily average is used as the expected rate.
+func probeHTTPEscalation(values url.Values, registry *prometheus.Registry, module Module) bool {
+	config := module.HTTP
+	client := clientWithTimeout(values, config.Escalation.Timeout, config.transport)
+
+	issues := config.Escalation.Issues
+	if i := values.Get("issue"); i != "" {
+		issues = []string{i}
+	}
+
+	metrics := &escalationMetrics{
+		escalating: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "sentry_issue_escalating",
+			Help: "Whether Sentry flagged the issue as escalating",
+		}, []string{"issue_id", "project"}),
+		events24h: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "sentry_issue_events_24h",
+			Help: "Number of events of the issue over the last 24 hours",
+		}, []string{"issue_id", "project"}),
+		baseline: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "sentry_issue_daily_events_baseline",
+			Help: "Average daily number of events of the issue over the last 30 days",
+		}, []string{"issue_id", "project"}),
+	}
+	registry.MustRegister(metrics.escalating, metrics.events24h, metrics.baseline)
+
+	config.logger().Infof("Processing escalation probe for %d Sentry issues\n", len(issues))
+
+	failures := 0
+	for _, id := range issues {
+		if err := requestIssueEscalation(id, config, client, metrics); err != nil {
+			failures++
+		}
+	}
+	registerFetchFailures(registry, failures)
+
+	config.logger().Infof("Processed escalation probe with %d fetch failures\n", failures)
+
+	return true
+}
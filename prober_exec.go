@@ -0,0 +1,136 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/url"
+	"os/exec"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+)
+
+// ExecProber runs an external command as a prober. The command receives the
+// module configuration and the probe parameters as a YAML document on stdin
+// and must write metrics in the Prometheus text exposition format to stdout.
+type ExecProber struct {
+	Command string         `yaml:"command"`
+	Args    []string       `yaml:"args"`
+	Timeout model.Duration `yaml:"timeout"`
+}
+
+type execProberInput struct {
+	Module HTTPProbe `yaml:"module"`
+	// The Authorization header sent to the organization's endpoints, with
+	// the token read from bearer_token_file or expanded from the
+	// environment.
+	Authorization string              `yaml:"authorization"`
+	Params        map[string][]string `yaml:"params"`
+}
+
+func execProber(e ExecProber) ProbeFn {
+	return func(values url.Values, registry *prometheus.Registry, module Module) bool {
+		input, err := yaml.Marshal(execProberInput{
+			Module:        module.HTTP,
+			Authorization: module.HTTP.authorization("organizations/" + module.HTTP.Organization + "/"),
+			Params:        values,
+		})
+		if err != nil {
+			module.HTTP.logger().Errorf("Error encoding input for %s: %s", e.Command, err)
+			return false
+		}
+
+		// The command is killed once the scrape is cancelled.
+		ctx := module.HTTP.context()
+		if e.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, time.Duration(e.Timeout))
+			defer cancel()
+		}
+
+		var stdout, stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, e.Command, e.Args...)
+		cmd.Stdin = bytes.NewReader(input)
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			module.HTTP.logger().Errorf("Error running %s: %s\n%s", e.Command, err, stderr.String())
+			return false
+		}
+
+		var parser expfmt.TextParser
+		families, err := parser.TextToMetricFamilies(&stdout)
+		if err != nil {
+			module.HTTP.logger().Errorf("Error parsing output of %s: %s", e.Command, err)
+			return false
+		}
+		if err := registry.Register(familiesCollector(families)); err != nil {
+			module.HTTP.logger().Errorf("Error registering output of %s: %s", e.Command, err)
+			return false
+		}
+		return true
+	}
+}
+
+// familiesCollector exposes already gathered metric families, such as the
+// parsed output of an exec prober, through a registry.
+type familiesCollector map[string]*dto.MetricFamily
+
+// Describe sends no descriptors, making this an unchecked collector.
+func (c familiesCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+func (c familiesCollector) Collect(ch chan<- prometheus.Metric) {
+	for name, mf := range c {
+		for _, m := range mf.Metric {
+			var labelNames, labelValues []string
+			for _, lp := range m.Label {
+				labelNames = append(labelNames, lp.GetName())
+				labelValues = append(labelValues, lp.GetValue())
+			}
+			desc := prometheus.NewDesc(name, mf.GetHelp(), labelNames, nil)
+
+			var metric prometheus.Metric
+			var err error
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				metric, err = prometheus.NewConstMetric(desc, prometheus.CounterValue, m.Counter.GetValue(), labelValues...)
+			case dto.MetricType_GAUGE:
+				metric, err = prometheus.NewConstMetric(desc, prometheus.GaugeValue, m.Gauge.GetValue(), labelValues...)
+			case dto.MetricType_SUMMARY:
+				quantiles := make(map[float64]float64)
+				for _, q := range m.Summary.Quantile {
+					quantiles[q.GetQuantile()] = q.GetValue()
+				}
+				metric, err = prometheus.NewConstSummary(desc, m.Summary.GetSampleCount(), m.Summary.GetSampleSum(), quantiles, labelValues...)
+			case dto.MetricType_HISTOGRAM:
+				buckets := make(map[float64]uint64)
+				for _, b := range m.Histogram.Bucket {
+					buckets[b.GetUpperBound()] = b.GetCumulativeCount()
+				}
+				metric, err = prometheus.NewConstHistogram(desc, m.Histogram.GetSampleCount(), m.Histogram.GetSampleSum(), buckets, labelValues...)
+			default:
+				metric, err = prometheus.NewConstMetric(desc, prometheus.UntypedValue, m.Untyped.GetValue(), labelValues...)
+			}
+			if err != nil {
+				metric = prometheus.NewInvalidMetric(desc, err)
+			}
+			ch <- metric
+		}
+	}
+}
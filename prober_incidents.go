@@ -0,0 +1,108 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"io"
+	"net/url"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type AlertRuleResponse struct {
+	ID       string   `json:"id"`
+	Name     string   `json:"name"`
+	Projects []string `json:"projects"`
+}
+
+type IncidentResponse struct {
+	ID        string            `json:"id"`
+	AlertRule AlertRuleResponse `json:"alertRule"`
+	Projects  []string          `json:"projects"`
+}
+
+func extractAlertRules(reader io.Reader) ([]AlertRuleResponse, error) {
+	var rules []AlertRuleResponse
+	err := decodeJSON(reader, &rules)
+	return rules, err
+}
+
+func extractIncidents(reader io.Reader) ([]IncidentResponse, error) {
+	var incidents []IncidentResponse
+	err := decodeJSON(reader, &incidents)
+	return incidents, err
+}
+
+// probeHTTPIncidents records Prometheus metrics on the metric alert rules of
+// the organization and whether they have an open incident for each project
+func probeHTTPIncidents(values url.Values, registry *prometheus.Registry, module Module) bool {
+	config := module.HTTP
+	client := clientWithTimeout(values, config.Incidents.Timeout, config.transport)
+
+	ruleGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sentry_alert_rule_info",
+		Help: "Metric alert rule of the organization, for each of its projects",
+	}, []string{"alert_rule", "id", "project"})
+	openGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sentry_incident_open",
+		Help: "Whether the alert rule has an open incident for the project",
+	}, []string{"alert_rule", "project"})
+	registry.MustRegister(ruleGauge, openGauge)
+
+	config.logger().Infof("Processing incidents probe\n")
+
+	failures := 0
+	rules := 0
+	err := requestSentryPages("organizations/"+config.Organization+"/alert-rules/", maxSentryPages, config, client, func(reader io.Reader) error {
+		page, err := extractAlertRules(reader)
+		if err != nil {
+			return err
+		}
+		for _, r := range page {
+			rules++
+			for _, p := range r.Projects {
+				ruleGauge.WithLabelValues(r.Name, r.ID, p).Set(1)
+				openGauge.WithLabelValues(r.Name, p)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		config.logger().Error(err)
+		failures++
+	}
+
+	incidents := 0
+	err = requestSentryPages("organizations/"+config.Organization+"/incidents/?status=open", maxSentryPages, config, client, func(reader io.Reader) error {
+		page, err := extractIncidents(reader)
+		if err != nil {
+			return err
+		}
+		for _, i := range page {
+			incidents++
+			for _, p := range i.Projects {
+				openGauge.WithLabelValues(i.AlertRule.Name, p).Set(1)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		config.logger().Error(err)
+		failures++
+	}
+	registerFetchFailures(registry, failures)
+
+	config.logger().Infof("Processed incidents probe for %d alert rules and %d open incidents with %d fetch failures\n", rules, incidents, failures)
+
+	return true
+}
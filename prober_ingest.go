@@ -0,0 +1,89 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Data categories probed by the ingest prober by default.
+var defaultIngestCategories = []string{"replay", "profile"}
+
+// probeHTTPIngest records Prometheus metrics on the ingestion of the session
+// replays and profiles of the projects of the target, the items
+// accepted and dropped over the period, using a single request to the
+// stats_v2 API. The totals of every category are exported even without any
+// item, so that a stream which stopped shows as 0 rather than as missing.
+func probeHTTPIngest(values url.Values, registry *prometheus.Registry, module Module) bool {
+	config := module.HTTP
+	client := clientWithTimeout(values, config.Ingest.Timeout, config.transport)
+
+	period, err := statsPeriod(values, config.Ingest.Period, time.Hour)
+	if err != nil {
+		config.logger().Error(err)
+		return false
+	}
+	categories := config.Ingest.Categories
+	if len(categories) == 0 {
+		categories = defaultIngestCategories
+	}
+
+	acceptedGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sentry_project_ingest_accepted_total",
+		Help: "Number of items of the category accepted for the project over the period",
+	}, []string{"project", "category"})
+	droppedGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sentry_project_ingest_dropped_total",
+		Help: "Number of items of the category dropped for the project over the period, whatever the outcome",
+	}, []string{"project", "category"})
+	totalAcceptedGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sentry_ingest_accepted_total",
+		Help: "Number of items of the category accepted across all projects over the period",
+	}, []string{"category"})
+	registry.MustRegister(acceptedGauge, droppedGauge, totalAcceptedGauge)
+
+	config.logger().Infof("Processing ingest probe of %v for period %s\n", categories, period)
+
+	failures := 0
+	target := targetParam(values)
+	groups, err := requestOrgStats(target, categories, period, config, client, &failures, registry)
+	if err == errNoMatchingProject {
+		config.logger().Errorf("No project of the organization matches the target %q", target)
+		registerFetchFailures(registry, failures)
+		return false
+	}
+	if err != nil {
+		config.logger().Error(err)
+		failures++
+	} else {
+		for _, category := range categories {
+			totalAcceptedGauge.WithLabelValues(category)
+		}
+	}
+	for _, group := range groups {
+		if group.outcome == "accepted" {
+			acceptedGauge.WithLabelValues(group.project, group.category).Add(group.quantity)
+			totalAcceptedGauge.WithLabelValues(group.category).Add(group.quantity)
+		} else {
+			droppedGauge.WithLabelValues(group.project, group.category).Add(group.quantity)
+		}
+	}
+	registerFetchFailures(registry, failures)
+
+	config.logger().Infof("Processed ingest probe with %d fetch failures\n", failures)
+
+	return true
+}
@@ -0,0 +1,136 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Searches counted for each project by the issue_counts prober, by status.
+var issueCountQueries = []struct {
+	status string
+	query  string
+}{
+	{"unresolved", "is:unresolved"},
+	{"ignored", "is:ignored"},
+	{"regressed", "is:regressed"},
+}
+
+func extractIssueCounts(reader io.Reader) (map[string]int, error) {
+	var counts map[string]int
+	err := decodeJSON(reader, &counts)
+	return counts, err
+}
+
+type issueCountsMetrics struct {
+	issues    *prometheus.GaugeVec
+	newIssues *prometheus.GaugeVec
+}
+
+// requestIssueCounts counts the issues of the project matching every query
+// with a single request to the issues-count API.
+func requestIssueCounts(target, projectID, period string, config HTTPProbe, client *http.Client, metrics *issueCountsMetrics) error {
+	newQuery := "age:-" + period
+	query := url.Values{}
+	query.Set("project", projectID)
+	query.Set("statsPeriod", period)
+	for _, q := range issueCountQueries {
+		query.Add("query", q.query)
+	}
+	query.Add("query", newQuery)
+
+	resp, err := requestSentry("organizations/"+config.Organization+"/issues-count/?"+query.Encode(), config, client)
+	if err == nil {
+		defer resp.Body.Close()
+		var counts map[string]int
+		counts, err = extractIssueCounts(resp.Body)
+		if err == nil {
+			for _, q := range issueCountQueries {
+				metrics.issues.WithLabelValues(target, q.status).Set(float64(counts[q.query]))
+			}
+			metrics.newIssues.WithLabelValues(target, period).Set(float64(counts[newQuery]))
+		}
+	}
+	if err != nil {
+		config.logger().Error(err)
+	}
+	return err
+}
+
+// probeHTTPIssueCounts records Prometheus metrics on the number of unresolved,
+// ignored and regressed issues of each Sentry project, along with the issues
+// first seen over the period
+func probeHTTPIssueCounts(values url.Values, registry *prometheus.Registry, module Module) bool {
+	target := targetParam(values)
+	config := module.HTTP
+	client := clientWithTimeout(values, config.IssueCounts.Timeout, config.transport)
+
+	period, err := statsPeriod(values, config.IssueCounts.Period, 24*time.Hour)
+	if err != nil {
+		config.logger().Error(err)
+		return false
+	}
+
+	metrics := &issueCountsMetrics{
+		issues: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "sentry_project_issues",
+			Help: "Number of issues of the project, by status",
+		}, []string{"project", "status"}),
+		newIssues: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "sentry_project_new_issues",
+			Help: "Number of issues of the project first seen over the period",
+		}, []string{"project", "period"}),
+	}
+	registry.MustRegister(metrics.issues, metrics.newIssues)
+
+	failures := 0
+
+	var targets []string
+	ids := make(map[string]string)
+	if resolved := resolveTargets(target, config, client, &failures, registry); len(resolved) > 0 {
+		slugs, err := sentryProjectSlugs(config, client)
+		if err != nil {
+			config.logger().Error(err)
+			failures++
+		}
+		for id, slug := range slugs {
+			ids[slug] = id
+		}
+		for _, t := range resolved {
+			if _, ok := ids[t]; ok {
+				targets = append(targets, t)
+			}
+		}
+	}
+	config.logger().Infof("Processing issue counts probe for %d Sentry projects over %s\n", len(targets), period)
+
+	var mu sync.Mutex
+	forEachTarget(targets, config.maxConcurrency(), nil, func(t string) {
+		if err := requestIssueCounts(t, ids[t], period, config, client, metrics); err != nil {
+			mu.Lock()
+			failures++
+			mu.Unlock()
+		}
+	})
+	registerFetchFailures(registry, failures)
+
+	config.logger().Infof("Processed issue counts probe with %d fetch failures\n", failures)
+
+	return true
+}
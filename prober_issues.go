@@ -13,79 +13,357 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
+	"hash/fnv"
 	"io"
-	"io/ioutil"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
 	"time"
 
-	"github.com/prometheus/common/log"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/model"
 )
 
-func requestIssueCountAboveThreshold(thresh int, statsPeriod string, config HTTPProbe, client *http.Client, w http.ResponseWriter) {
-	issuesList := make(map[string]int)
-	extra := ""
-	total := 0
+// Share of the remaining probe deadline after which no further pages of
+// issues are requested.
+const issuesDeadlineFraction = 0.8
 
-	var newIssuesList map[string]int
-	var err error
-	for {
-		log.Infof("Querying issues list with cursor '%s'", extra)
-		newIssuesList, extra, err = getIssuesListByFreq(thresh, statsPeriod, extra, config, client)
+// issueSeries identifies the series of a single issue, or of the issues
+// of a project sharing a title or title hash and a level.
+type issueSeries struct {
+	project string
+	issue   string
+	level   string
+}
+
+// issueLabel returns the value of the issue label of the issue according to
+// the configured mode. The title_hash mode uses a short stable hash of the
+// culprit and title, so that series stay groupable without free-text label
+// values.
+func issueLabel(issue IssuesResponse, mode string) string {
+	switch mode {
+	case "title":
+		return issue.Title
+	case "title_hash":
+		h := fnv.New32a()
+		h.Write([]byte(issue.Culprit + "\x00" + issue.Title))
+		return fmt.Sprintf("%08x", h.Sum32())
+	}
+	return issue.Id
+}
+
+// issueLabelName returns the name of the issue label of per-issue metrics,
+// after the configured mode, so that values of different modes are not
+// joined together.
+func issueLabelName(mode string) string {
+	switch mode {
+	case "title":
+		return "issue_title"
+	case "title_hash":
+		return "issue_title_hash"
+	}
+	return "issue_id"
+}
+
+// Default number of issues exported by per-issue metrics.
+const defaultMaxIssues = 100
+
+func (o IssuesOptions) maxIssues() int {
+	if o.MaxIssues > 0 {
+		return o.MaxIssues
+	}
+	return defaultMaxIssues
+}
+
+// Default and maximum number of issues per page, the latter being the
+// maximum limit of the Sentry API.
+const (
+	defaultIssuesPageSize = 25
+	maxIssuesPageSize     = 100
+)
+
+func (o IssuesOptions) maxPages() int {
+	if o.MaxPages > 0 {
+		return o.MaxPages
+	}
+	return maxSentryPages
+}
+
+func (o IssuesOptions) pageSize() int {
+	if o.PageSize > 0 {
+		return o.PageSize
+	}
+	return defaultIssuesPageSize
+}
+
+func (o IssuesOptions) validate() error {
+	if o.MaxPages < 0 {
+		return fmt.Errorf("issues max_pages must not be negative")
+	}
+	if o.PageSize < 0 || o.PageSize > maxIssuesPageSize {
+		return fmt.Errorf("issues page_size must be between 1 and %d, got %d", maxIssuesPageSize, o.PageSize)
+	}
+	return nil
+}
+
+// issueCount is an issue above the threshold along with its number of events
+// over the period.
+type issueCount struct {
+	issue IssuesResponse
+	count int
+}
+
+// topIssues returns the max issues with the most events, by decreasing number
+// of events.
+func topIssues(issues map[string]issueCount, max int) []issueCount {
+	var top []issueCount
+	for _, i := range issues {
+		top = append(top, i)
+	}
+	sort.Slice(top, func(a, b int) bool {
+		if top[a].count != top[b].count {
+			return top[a].count > top[b].count
+		}
+		return top[a].issue.Id < top[b].issue.Id
+	})
+	if len(top) > max {
+		top = top[:max]
+	}
+	return top
+}
+
+// issuesQuery selects the issues counted by the issues probe.
+type issuesQuery struct {
+	query       string
+	sort        string
+	environment string
+}
+
+// encode returns the query string parameters selecting the issues.
+func (q issuesQuery) encode() string {
+	params := url.Values{}
+	params.Set("query", q.query)
+	params.Set("sort", q.sort)
+	if q.environment != "" {
+		params.Set("environment", q.environment)
+	}
+	return params.Encode()
+}
+
+// highFreqIssues are the issues matching a query with more events than a
+// threshold over a period.
+type highFreqIssues struct {
+	perProject map[string]int
+	// Only filled with per-issue or per-team metrics enabled.
+	perIssue map[string]issueCount
+	total    int
+	// Whether pagination stopped early because the probe deadline was
+	// nearly reached, or why it was aborted.
+	truncated bool
+	aborted   string
+}
+
+// requestHighFreqIssues pages through the issues matching the query until
+// one is below the threshold, the deadline nearly reached or the pages
+// exhausted. The next page is requested along with the stats of the issues
+// of the current one, which costs a page requested in vain once an issue is
+// below the threshold but halves the duration of multi-page probes.
+func requestHighFreqIssues(thresh int, statsPeriod string, query issuesQuery, deadline time.Time, config HTTPProbe, client *http.Client) highFreqIssues {
+	issues := highFreqIssues{
+		perProject: make(map[string]int),
+		perIssue:   make(map[string]issueCount),
+	}
+
+	var cutoff time.Time
+	if !deadline.IsZero() {
+		cutoff = time.Now().Add(time.Duration(float64(time.Until(deadline)) * issuesDeadlineFraction))
+	}
+
+	// Why the page at the cursor was not requested, only reported when it
+	// turns out to be needed.
+	var truncated bool
+	var aborted string
+	guard := newCursorGuard(config.Issues.maxPages())
+	fetch := func(cursor string) <-chan issuesPage {
+		if !cutoff.IsZero() && cursor != "" && time.Now().After(cutoff) {
+			truncated = true
+			return nil
+		}
+		if aborted = guard.next(cursor); aborted != "" {
+			return nil
+		}
+		config.logger().Infof("Querying issues list with cursor '%s'", cursor)
+		ch := make(chan issuesPage, 1)
+		go func() {
+			ch <- getIssuesPage(statsPeriod, query, cursor, config, client)
+		}()
+		return ch
+	}
+
+	pending := fetch("")
+	for pending != nil {
+		page := <-pending
+		pending = nil
+		if page.err != nil {
+			config.logger().Error(page.err)
+			break
+		}
+		if page.next != "" {
+			pending = fetch(page.next)
+		}
+		counts, more, err := countIssuesAboveThreshold(page.issues, thresh, statsPeriod, query, config, client, issues.perIssue)
 		if err != nil {
-			log.Error(err)
+			config.logger().Error(err)
 			break
 		}
-		for project, _ := range newIssuesList {
-			issuesList[project] += newIssuesList[project]
-			total += newIssuesList[project]
+		for project, count := range counts {
+			issues.perProject[project] += count
+			issues.total += count
 		}
-		if extra == "" {
+		if !more {
 			break
 		}
+		if pending == nil && page.next != "" {
+			if issues.truncated, issues.aborted = truncated, aborted; truncated {
+				config.logger().Warnf("Probe deadline nearly reached, not querying issues list with cursor '%s'", page.next)
+			} else {
+				config.logger().Errorf("Stopping issues pagination at cursor '%s': %s", page.next, aborted)
+			}
+		}
+	}
+	if pending != nil {
+		// Requested in vain, its response is discarded.
+		<-pending
 	}
+	return issues
+}
 
-	for project, _ := range issuesList {
-		fmt.Fprintf(w, "sentry_project_high_freq_issues{project=\""+project+"\",above=\"%d\",period=\""+statsPeriod+"\"} %d.0\n", thresh, issuesList[project])
+func requestIssueCountAboveThreshold(thresh int, statsPeriod string, query issuesQuery, deadline time.Time, config HTTPProbe, client *http.Client, registry *prometheus.Registry) {
+	issues := requestHighFreqIssues(thresh, statsPeriod, query, deadline, config, client)
+	issuesList, perIssue, total := issues.perProject, issues.perIssue, issues.total
+
+	projectIssuesGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sentry_project_high_freq_issues",
+		Help: "Number of issues of the project matching the query with more events than the threshold over the period",
+	}, []string{"project", "above", "period"})
+	issuesGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sentry_high_freq_issues",
+		Help: "Number of issues matching the query with more events than the threshold over the period",
+	}, []string{"above", "period"})
+	truncatedGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "sentry_high_freq_issues_truncated",
+		Help: "Whether pagination stopped early because the probe deadline was nearly reached",
+	})
+	registry.MustRegister(projectIssuesGauge, issuesGauge, truncatedGauge)
+	if config.Issues.PerIssue {
+		eventsGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "sentry_issue_events",
+			Help: "Number of events over the period of the issues with the most events above the threshold",
+		}, []string{issueLabelName(config.Issues.IssueLabel), "project", "level"})
+		ageGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "sentry_issue_age_seconds",
+			Help: "Seconds since the issues with the most events above the threshold were first seen",
+		}, []string{issueLabelName(config.Issues.IssueLabel), "project", "level"})
+		registry.MustRegister(eventsGauge, ageGauge)
+
+		top := topIssues(perIssue, config.Issues.maxIssues())
+		if len(top) < len(perIssue) {
+			config.logger().Infof("Exporting the %d issues with the most events out of %d\n", len(top), len(perIssue))
+		}
+		counts := make(map[issueSeries]int)
+		firstSeen := make(map[issueSeries]time.Time)
+		for _, i := range top {
+			s := issueSeries{i.issue.Project.Slug, issueLabel(i.issue, config.Issues.IssueLabel), i.issue.Level}
+			counts[s] += i.count
+			if seen, ok := firstSeen[s]; !i.issue.FirstSeen.IsZero() && (!ok || i.issue.FirstSeen.Before(seen)) {
+				firstSeen[s] = i.issue.FirstSeen
+			}
+		}
+		for s, count := range counts {
+			eventsGauge.WithLabelValues(s.issue, s.project, s.level).Set(float64(count))
+			if seen, ok := firstSeen[s]; ok {
+				ageGauge.WithLabelValues(s.issue, s.project, s.level).Set(time.Since(seen).Seconds())
+			}
+		}
 	}
 
-	fmt.Fprintf(w, "sentry_high_freq_issues{above=\"%d\",period=\""+statsPeriod+"\"} %d.0\n", thresh, total)
+	if config.Issues.Owners {
+		registerTeamIssues(perIssue, thresh, statsPeriod, config, client, registry)
+	}
+
+	above := strconv.Itoa(thresh)
+	for project, _ := range issuesList {
+		projectIssuesGauge.WithLabelValues(project, above, statsPeriod).Set(float64(issuesList[project]))
+	}
+	issuesGauge.WithLabelValues(above, statsPeriod).Set(float64(total))
+	if issues.truncated {
+		truncatedGauge.Set(1)
+	}
+	registerPaginationAborted(registry, issues.aborted)
 }
 
-func getIssuesListByFreq(thresh int, statsPeriod, extra string, config HTTPProbe, client *http.Client) (map[string]int, string, error) {
-	countPerProject := make(map[string]int)
-	nextExtra := ""
+// issuesPage is a page of issues along with the cursor of the next page,
+// empty on the last page.
+type issuesPage struct {
+	issues []IssuesResponse
+	next   string
+	err    error
+}
 
-	url := "organizations/" + config.Organization + "/issues/?collapse=stats&expand=owners&expand=inbox&limit=25&query=is%3Aunresolved&sort=freq&statsPeriod=" + statsPeriod + "&" + extra
+// getIssuesPage requests the page of issues at the cursor extra.
+func getIssuesPage(statsPeriod string, query issuesQuery, extra string, config HTTPProbe, client *http.Client) issuesPage {
+	url := "organizations/" + config.Organization + "/issues/?collapse=stats&expand=owners&expand=inbox&limit=" + strconv.Itoa(config.Issues.pageSize()) + "&" + query.encode() + "&statsPeriod=" + statsPeriod + "&" + extra
 	resp, err := requestSentry(url, config, client)
 	if err != nil {
-		log.Error(err)
-		return countPerProject, nextExtra, err
+		return issuesPage{err: err}
 	}
+	defer resp.Body.Close()
 	issues, err := extractIssues(resp.Body)
 	if err != nil {
-		log.Error(err)
-		return countPerProject, nextExtra, err
+		return issuesPage{err: err}
+	}
+	next, err := getSentryNextCursor(resp)
+	if err != nil {
+		config.logger().Error(err)
 	}
+	return issuesPage{issues: issues, next: next}
+}
+
+// countIssuesAboveThreshold returns the number of issues of the page above
+// the threshold per project, from the stats of the issues, and whether the
+// next page may have more. With per-issue or per-team metrics enabled, the
+// event counts of those issues are added to perIssue. When the issues are
+// sorted by frequency, no further page is needed once an issue is below the
+// threshold.
+func countIssuesAboveThreshold(issues []IssuesResponse, thresh int, statsPeriod string, query issuesQuery, config HTTPProbe, client *http.Client, perIssue map[string]issueCount) (map[string]int, bool, error) {
+	countPerProject := make(map[string]int)
 	issueIdToProject := make(map[string]string)
+	issuesById := make(map[string]IssuesResponse)
 	var allIds []string
 	for _, issue := range issues {
 		issueIdToProject[issue.Id] = issue.Project.Slug
+		issuesById[issue.Id] = issue
 		allIds = append(allIds, issue.Id)
 	}
 
 	if len(allIds) == 0 {
-		return countPerProject, nextExtra, nil
+		return countPerProject, false, nil
 	}
 
-	countPerIssueIds, err := getIssueCountForIds(allIds, statsPeriod, config, client)
-	if err != nil {
-		log.Error(err)
-		return countPerProject, nextExtra, err
+	var countPerIssueIds map[string]int
+	if !config.issueCountsFromList() {
+		var err error
+		countPerIssueIds, err = getIssueCountForIds(allIds, statsPeriod, query, config, client)
+		if isNotFound(err) {
+			config.fallBackToIssuesList()
+		} else if err != nil {
+			return countPerProject, false, err
+		}
+	}
+	if config.issueCountsFromList() {
+		countPerIssueIds = listedIssueCounts(issues)
 	}
 
 	more := true
@@ -93,25 +371,31 @@ func getIssuesListByFreq(thresh int, statsPeriod, extra string, config HTTPProbe
 		if projectId, ok := issueIdToProject[id]; ok {
 			if countPerIssueIds[id] >= thresh {
 				countPerProject[projectId]++
-			} else {
+				if config.Issues.PerIssue || config.Issues.Owners {
+					perIssue[id] = issueCount{issuesById[id], countPerIssueIds[id]}
+				}
+			} else if query.sort == "freq" {
 				more = false
 			}
 		}
 	}
-
-	if more {
-		nextExtra, err = getSentryNextCursor(resp)
-		if err != nil {
-			log.Error(err)
-		}
-	}
-
-	return countPerProject, nextExtra, nil
+	return countPerProject, more, nil
 }
 
 type IssuesResponse struct {
-	Id      string        `json:"id"`
-	Project IssuesProject `json:"project"`
+	Id      string `json:"id"`
+	Title   string `json:"title"`
+	Culprit string `json:"culprit"`
+	Level   string `json:"level"`
+	// Number of events, used when the Sentry API lacks the issues-stats
+	// API.
+	Count string `json:"count"`
+	// Zero when missing from the response.
+	FirstSeen time.Time     `json:"firstSeen"`
+	Project   IssuesProject `json:"project"`
+	Owners    []IssueOwner  `json:"owners"`
+	// Nil for unassigned issues.
+	AssignedTo *IssueAssignee `json:"assignedTo"`
 }
 
 type IssuesProject struct {
@@ -121,11 +405,7 @@ type IssuesProject struct {
 
 func extractIssues(reader io.Reader) ([]IssuesResponse, error) {
 	var keys []IssuesResponse
-	body, err := ioutil.ReadAll(reader)
-	if err != nil {
-		return keys, err
-	}
-	err = json.Unmarshal([]byte(body), &keys)
+	err := decodeJSON(reader, &keys)
 	if err != nil {
 		return keys, err
 	}
@@ -144,24 +424,21 @@ type IssuesStats struct {
 	LastSeen  string `json:"lastSeen"`
 }
 
-func getIssueCountForIds(ids []string, statsPeriod string, config HTTPProbe, client *http.Client) (map[string]int, error) {
+func getIssueCountForIds(ids []string, statsPeriod string, query issuesQuery, config HTTPProbe, client *http.Client) (map[string]int, error) {
 	ret := make(map[string]int)
-	query := ""
+	groups := ""
 	for _, id := range ids {
-		query += fmt.Sprintf("&groups=%s", id)
+		groups += fmt.Sprintf("&groups=%s", id)
 	}
-	url := "organizations/" + config.Organization + "/issues-stats/?query=is:unresolved&sort=freq&statsPeriod=" + statsPeriod + query
+	url := "organizations/" + config.Organization + "/issues-stats/?" + query.encode() + "&statsPeriod=" + statsPeriod + groups
 	resp, err := requestSentry(url, config, client)
 	if err != nil {
 		return ret, err
 	}
+	defer resp.Body.Close()
 
 	var data []IssuesStatsResponse
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return ret, err
-	}
-	err = json.Unmarshal([]byte(body), &data)
+	err = decodeJSON(resp.Body, &data)
 	if err != nil {
 		return ret, err
 	}
@@ -176,13 +453,14 @@ func getIssueCountForIds(ids []string, statsPeriod string, config HTTPProbe, cli
 	return ret, nil
 }
 
-func clientWithTimeout(values url.Values, timeout time.Duration) *http.Client {
+func clientWithTimeout(values url.Values, timeout model.Duration, transport http.RoundTripper) *http.Client {
 	c := &http.Client{
-		Timeout: timeout,
+		Timeout:   time.Duration(timeout),
+		Transport: transport,
 	}
 
 	if timeout := values.Get("timeout"); timeout != "" {
-		if d, err := model.ParseDuration(timeout); err != nil {
+		if d, err := model.ParseDuration(timeout); err == nil {
 			c.Timeout = time.Duration(d)
 		}
 	}
@@ -190,12 +468,9 @@ func clientWithTimeout(values url.Values, timeout time.Duration) *http.Client {
 	return c
 }
 
-// probeHTTPIssues writes Prometheus metrics on the number of issues which trigger
-// at high frequency
-func probeHTTPIssues(values url.Values, w http.ResponseWriter, module Module) bool {
-	config := module.HTTP
-	client := clientWithTimeout(values, config.Issues.Timeout)
-
+// issuesParams returns the threshold, period and query of the issues to
+// count, from the issues options of the module and the probe parameters.
+func issuesParams(values url.Values, config HTTPProbe) (int, string, issuesQuery, error) {
 	above := 10000
 	if a := config.Issues.Above; a > 0 {
 		above = a
@@ -204,23 +479,57 @@ func probeHTTPIssues(values url.Values, w http.ResponseWriter, module Module) bo
 		above, _ = strconv.Atoi(a)
 	}
 
-	period := "14d"
-	if p := config.Issues.Period; p != "" {
-		period = p
+	period, err := statsPeriod(values, config.Issues.Period, 14*24*time.Hour)
+	if err != nil {
+		return 0, "", issuesQuery{}, err
+	}
+
+	query := issuesQuery{
+		query: "is:unresolved",
+		sort:  "freq",
+	}
+	if q := config.Issues.Query; q != "" {
+		query.query = q
+	}
+	if q := values.Get("query"); q != "" {
+		query.query = q
+	}
+	if s := config.Issues.Sort; s != "" {
+		query.sort = s
 	}
-	if p := values.Get("period"); p != "" {
-		period = p
+	if s := values.Get("sort"); s != "" {
+		query.sort = s
 	}
-	if period != "14d" && period != "24h" {
-		log.Error("Invalid period (must be 14d or 24h)")
+	query.environment = config.Issues.Environment
+	if e := values.Get("environment"); e != "" {
+		query.environment = e
+	}
+	return above, period, query, nil
+}
+
+// probeHTTPIssues records Prometheus metrics on the number of issues which trigger
+// at high frequency
+func probeHTTPIssues(values url.Values, registry *prometheus.Registry, module Module) bool {
+	config := module.HTTP
+	client := clientWithTimeout(values, config.Issues.Timeout, config.transport)
+
+	above, period, query, err := issuesParams(values, config)
+	if err != nil {
+		config.logger().Error(err)
 		return false
 	}
 
-	log.Infof("Processing issues probe for period %s above %d\n", period, above)
+	config.logger().Infof("Processing issues probe for '%s' sorted by %s for period %s above %d\n", query.query, query.sort, period, above)
+
+	var deadline time.Time
+	if config.Issues.Deadline > 0 {
+		deadline = time.Now().Add(time.Duration(config.Issues.Deadline))
+	}
+	deadline = config.deadline(deadline)
 
-	requestIssueCountAboveThreshold(above, period, config, client, w)
+	requestIssueCountAboveThreshold(above, period, query, deadline, config, client, registry)
 
-	log.Infof("Processed issues probe\n")
+	config.logger().Infof("Processed issues probe\n")
 
 	return true
 }
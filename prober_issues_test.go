@@ -0,0 +1,42 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+func TestClientWithTimeout(t *testing.T) {
+	for _, c := range []struct {
+		param string
+		want  time.Duration
+	}{
+		{"", 30 * time.Second},
+		{"5s", 5 * time.Second},
+		{"1m", time.Minute},
+		{"invalid", 30 * time.Second},
+	} {
+		values := url.Values{}
+		if c.param != "" {
+			values.Set("timeout", c.param)
+		}
+		client := clientWithTimeout(values, model.Duration(30*time.Second), nil)
+		if client.Timeout != c.want {
+			t.Errorf("timeout=%q: client timeout %s, want %s", c.param, client.Timeout, c.want)
+		}
+	}
+}
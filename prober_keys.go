@@ -0,0 +1,141 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type KeyStatsResponse struct {
+	Accepted int `json:"accepted"`
+	Filtered int `json:"filtered"`
+	Dropped  int `json:"dropped"`
+}
+
+// extractKeyStats returns the number of events received through the key by
+// outcome, summed over every bucket.
+func extractKeyStats(reader io.Reader) (map[string]int, error) {
+	var stats []KeyStatsResponse
+	totals := make(map[string]int)
+	if err := decodeJSON(reader, &stats); err != nil {
+		return totals, err
+	}
+	for _, s := range stats {
+		totals["accepted"] += s.Accepted
+		totals["filtered"] += s.Filtered
+		totals["dropped"] += s.Dropped
+	}
+	return totals, nil
+}
+
+type keysMetrics struct {
+	rateLimit *prometheus.GaugeVec
+	active    *prometheus.GaugeVec
+	events    *prometheus.GaugeVec
+}
+
+// requestProjectKeys records the metrics of every key of the project and
+// returns the number of failed requests.
+func requestProjectKeys(target string, config HTTPProbe, client *http.Client, metrics *keysMetrics) int {
+	resp, err := requestSentry("projects/"+config.Organization+"/"+target+"/keys/", config, client)
+	if err != nil {
+		config.logger().Error(err)
+		return 1
+	}
+	defer resp.Body.Close()
+	keys, err := extractProjectKeys(resp.Body)
+	if err != nil {
+		config.logger().Error(err)
+		return 1
+	}
+
+	failures := 0
+	since := strconv.FormatInt(time.Now().Unix()-60*60, 10)
+	for _, key := range keys {
+		label := key.label()
+		metrics.rateLimit.WithLabelValues(target, label).Set(key.rateLimit())
+		active := 0.0
+		if key.IsActive {
+			active = 1
+		}
+		metrics.active.WithLabelValues(target, label).Set(active)
+
+		resp, err := requestSentry("projects/"+config.Organization+"/"+target+"/keys/"+key.ID+"/stats/?resolution=10s&since="+since, config, client)
+		if err != nil {
+			config.logger().Error(err)
+			failures++
+			continue
+		}
+		totals, err := extractKeyStats(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			config.logger().Error(err)
+			failures++
+			continue
+		}
+		for outcome, total := range totals {
+			metrics.events.WithLabelValues(target, label, outcome).Set(float64(total))
+		}
+	}
+	return failures
+}
+
+// probeHTTPKeys records Prometheus metrics on every client key (DSN) of each
+// Sentry project: its rate limit, whether it is active and its events over
+// the last hour
+func probeHTTPKeys(values url.Values, registry *prometheus.Registry, module Module) bool {
+	target := targetParam(values)
+	config := module.HTTP
+	client := clientWithTimeout(values, config.Keys.Timeout, config.transport)
+
+	metrics := &keysMetrics{
+		rateLimit: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "sentry_project_key_rate_limit_events_per_second",
+			Help: "Rate limit of the project key in events per second, 0 if unlimited",
+		}, []string{"project", "key_label"}),
+		active: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "sentry_project_key_active",
+			Help: "Whether the project key is active",
+		}, []string{"project", "key_label"}),
+		events: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "sentry_project_key_events_1h",
+			Help: "Number of events received through the project key over the last hour, by outcome",
+		}, []string{"project", "key_label", "outcome"}),
+	}
+	registry.MustRegister(metrics.rateLimit, metrics.active, metrics.events)
+
+	failures := 0
+
+	targets := resolveTargets(target, config, client, &failures, registry)
+	config.logger().Infof("Processing keys probe for %d Sentry projects\n", len(targets))
+
+	var mu sync.Mutex
+	forEachTarget(targets, config.maxConcurrency(), nil, func(t string) {
+		f := requestProjectKeys(t, config, client, metrics)
+		mu.Lock()
+		failures += f
+		mu.Unlock()
+	})
+	registerFetchFailures(registry, failures)
+
+	config.logger().Infof("Processed keys probe with %d fetch failures\n", failures)
+
+	return true
+}
@@ -13,17 +13,14 @@
 package main
 
 import (
-	"encoding/json"
-	"fmt"
 	"io"
-	"io/ioutil"
 	"net/http"
 	"net/url"
 	"strconv"
 	"sync"
 	"time"
 
-	"github.com/prometheus/common/log"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 type RateLimitResponse struct {
@@ -35,37 +32,57 @@ type ProjectKeyResponse struct {
 	ID        string
 	Name      string
 	Label     string
+	IsActive  bool
 	RateLimit *RateLimitResponse
 }
 
-func extractRateLimit(reader io.Reader) (float64, error) {
-	var keys []ProjectKeyResponse
-	body, err := ioutil.ReadAll(reader)
-	if err != nil {
-		return 0, err
+// label returns the label of the key, falling back to its name.
+func (k ProjectKeyResponse) label() string {
+	if k.Label == "" {
+		return k.Name
+	}
+	return k.Label
+}
+
+// rateLimit returns the rate limit of the key in events per second, or 0 if
+// it has none.
+func (k ProjectKeyResponse) rateLimit() float64 {
+	if k.RateLimit == nil || k.RateLimit.Window == 0 {
+		return 0
 	}
-	err = json.Unmarshal([]byte(body), &keys)
+	return float64(k.RateLimit.Count) / float64(k.RateLimit.Window)
+}
+
+func extractProjectKeys(reader io.Reader) ([]ProjectKeyResponse, error) {
+	var keys []ProjectKeyResponse
+	err := decodeJSON(reader, &keys)
+	return keys, err
+}
+
+// extractRateLimit returns the rate limit of every key of the project, in
+// events per second, keyed by the key label. Keys without a rate limit
+// report 0.
+func extractRateLimit(reader io.Reader) (map[string]float64, error) {
+	rates := make(map[string]float64)
+	keys, err := extractProjectKeys(reader)
 	if err != nil {
-		return 0, err
+		return rates, err
 	}
-	if len(keys) == 0 || keys[0].RateLimit == nil {
-		return 0, nil
+	for _, key := range keys {
+		rates[key.label()] = key.rateLimit()
 	}
-	return float64(keys[0].RateLimit.Count) / float64(keys[0].RateLimit.Window), nil
+	return rates, nil
 }
 
-func extractErrorRate(reader io.Reader) (int, int, error) {
+func extractStats(reader io.Reader) ([][]int, error) {
 	var stats [][]int
+	err := decodeJSON(reader, &stats)
+	return stats, err
+}
+
+func extractErrorRate(stats [][]int) (int, int) {
 	count := 0
 	latestTimestamp := 0
-	body, err := ioutil.ReadAll(reader)
-	if err != nil {
-		return 0, 0, err
-	}
-	err = json.Unmarshal([]byte(body), &stats)
-	if err != nil {
-		return 0, 0, err
-	}
 	// ignore the last timestamp
 	for i := len(stats) - 1; i >= 0; i-- {
 		ts := stats[i][0]
@@ -75,98 +92,262 @@ func extractErrorRate(reader io.Reader) (int, int, error) {
 			latestTimestamp = ts
 		}
 	}
-	return count, latestTimestamp, nil
+	return count, latestTimestamp
 }
+
+// Window whose event rate is compared against the trailing hour.
+const surgeWindow = 5 * time.Minute
+
+// extractSurgeRatio returns the ratio between the event rate of the last
+// surgeWindow and the event rate over the whole hour of stats. It returns
+// false when the hour had no events.
+func extractSurgeRatio(stats [][]int, now time.Time) (float64, bool) {
+	since := now.Add(-surgeWindow).Unix()
+	recent := 0
+	total := 0
+	for _, s := range stats {
+		total += s[1]
+		if int64(s[0]) >= since {
+			recent += s[1]
+		}
+	}
+	if total == 0 {
+		return 0, false
+	}
+	recentRate := float64(recent) / surgeWindow.Seconds()
+	hourlyRate := float64(total) / time.Hour.Seconds()
+	return recentRate / hourlyRate, true
+}
+
 func generateLag(latestTimestamp int) int64 {
 	return time.Now().Unix() - int64(latestTimestamp)
 }
 
-func requestEventCount(target string, stat string, config HTTPProbe, client *http.Client, w http.ResponseWriter) (int, error) {
+type lagMetrics struct {
+	events          *prometheus.GaugeVec
+	latestTimestamp *prometheus.GaugeVec
+	lag             *prometheus.GaugeVec
+	rateLimit       *prometheus.GaugeVec
+	surge           *prometheus.GaugeVec
+}
+
+func newLagMetrics(registry *prometheus.Registry) *lagMetrics {
+	m := &lagMetrics{
+		events: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "sentry_events_1h_total",
+			Help: "Number of events of the project over the last hour, by stat",
+		}, []string{"stat", "project"}),
+		latestTimestamp: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "sentry_project_latest_timestamp",
+			Help: "Timestamp of the latest stats bucket of the project containing events",
+		}, []string{"stat", "project"}),
+		lag: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "sentry_project_lag_seconds",
+			Help: "Seconds since the latest stats bucket of the project containing events",
+		}, []string{"stat", "project"}),
+		rateLimit: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "sentry_project_key_rate_limit_events_per_second",
+			Help: "Rate limit of the project key, in events per second (0 when unlimited)",
+		}, []string{"project", "key_label"}),
+		surge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "sentry_project_event_surge_ratio",
+			Help: "Ratio between the rate of received events of the project over the last 5 minutes and over the last hour",
+		}, []string{"project"}),
+	}
+	registry.MustRegister(m.events, m.lag, m.surge)
+	return m
+}
+
+// projectStats are the stats of a project over the last hour.
+type projectStats struct {
+	events int
+	// Timestamp of the latest bucket containing events, 0 without events.
+	latestTimestamp int
+	// Ratio between the event rate of the last surgeWindow and of the
+	// hour, only set when the hour had events.
+	surge    float64
+	hasSurge bool
+}
+
+// requestEventCount returns the stats of the given stat of the project over
+// the last hour.
+func requestEventCount(target string, stat string, config HTTPProbe, client *http.Client) (projectStats, error) {
 	// Get the last hour stats
 	var lastMin = strconv.FormatInt(time.Now().Unix()-60*60, 10)
 	resp, err := requestSentry("projects/"+config.Organization+"/"+target+"/stats/?resolution=10s&stat="+stat+"&since="+lastMin, config, client)
+	if err != nil {
+		return projectStats{}, err
+	}
+	defer resp.Body.Close()
+
+	stats, err := extractStats(resp.Body)
+	var ps projectStats
+	ps.events, ps.latestTimestamp = extractErrorRate(stats)
+	ps.surge, ps.hasSurge = extractSurgeRatio(stats, time.Now())
+	return ps, err
+}
 
-	var rate int
-	var latestTimestamp int
-	if err == nil {
-		defer resp.Body.Close()
-		rate, latestTimestamp, err = extractErrorRate(resp.Body)
-		lag := generateLag(latestTimestamp)
-		fmt.Fprintf(w, "sentry_events_1h_total{stat=\""+stat+"\",project=\""+target+"\"} %d\n", rate)
-		if latestTimestamp > 0 {
-			fmt.Fprintf(w, "sentry_project_latest_timestamp{stat=\""+stat+"\",project=\""+target+"\"} %d\n", latestTimestamp)
-			fmt.Fprintf(w, "sentry_project_lag_seconds{stat=\""+stat+"\",project=\""+target+"\"} %d\n", lag)
+// recordStats records the stats of the given stat of the project, the surge
+// ratio being only recorded for received events.
+func (m *lagMetrics) recordStats(target, stat string, ps projectStats, latestTimestamp bool) {
+	m.events.WithLabelValues(stat, target).Set(float64(ps.events))
+	if ps.latestTimestamp > 0 {
+		if latestTimestamp {
+			m.latestTimestamp.WithLabelValues(stat, target).Set(float64(ps.latestTimestamp))
 		}
-	} else {
-		log.Error(err)
+		m.lag.WithLabelValues(stat, target).Set(float64(generateLag(ps.latestTimestamp)))
+	}
+	if ps.hasSurge && stat == "received" {
+		m.surge.WithLabelValues(target).Set(ps.surge)
 	}
-	return latestTimestamp, err
 }
 
-func requestRateLimit(target string, config HTTPProbe, client *http.Client, w http.ResponseWriter) error {
+// requestRateLimit returns the rate limits of the keys of the project by
+// key label, in events per second.
+func requestRateLimit(target string, config HTTPProbe, client *http.Client) (map[string]float64, error) {
 	resp, err := requestSentry("projects/"+config.Organization+"/"+target+"/keys/", config, client)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return extractRateLimit(resp.Body)
+}
 
-	var rate float64
-	if err == nil {
-		defer resp.Body.Close()
-		rate, err = extractRateLimit(resp.Body)
-		fmt.Fprintf(w, "sentry_project_rate_limit_seconds_total{project=\""+target+"\"} %f\n", rate)
-	} else {
-		log.Error(err)
+func (m *lagMetrics) recordRateLimits(target string, rates map[string]float64) {
+	for label, rate := range rates {
+		m.rateLimit.WithLabelValues(target, label).Set(rate)
 	}
-	return err
 }
 
-// probeProjectLag writes Prometheus metrics on the count of issues processed for each
-// Sentry project as well as the observed lag in processing issues for those projects
-func probeProjectLag(target string, config HTTPProbe, client *http.Client, w http.ResponseWriter, failures *int, lastTsChan chan<- int, wg *sync.WaitGroup) {
-	defer wg.Done()
-	var err error
-	var latestTimestamp int
+// lagRequestKeys is the request of the keys of a project made by the lag
+// prober along with its stats.
+const lagRequestKeys = "keys"
 
-	latestTimestamp, err = requestEventCount(target, "received", config, client, w)
-	if err != nil {
-		*failures++
+// projectRequests returns the requests made by the lag prober for each
+// project: the stats of each counted stat, then the keys for rate limits.
+func (l LagOptions) projectRequests() []string {
+	requests := []string{"received"}
+	if l.rejected() {
+		requests = append(requests, "rejected")
 	}
-	_, err = requestEventCount(target, "rejected", config, client, w)
-	if err != nil {
-		*failures++
+	if l.rateLimit() {
+		requests = append(requests, lagRequestKeys)
+	}
+	return requests
+}
+
+// statsConcurrency returns the number of the requests of a project made at
+// once, all of them by default.
+func (l LagOptions) statsConcurrency(requests int) int {
+	if l.StatsConcurrency > 0 && l.StatsConcurrency < requests {
+		return l.StatsConcurrency
 	}
-	if config.Lag.RateLimit {
-		err = requestRateLimit(target, config, client, w)
-		if err != nil {
-			*failures++
+	return requests
+}
+
+// probeProjectLag records Prometheus metrics on the count of issues processed for each
+// Sentry project as well as the observed lag in processing issues for those projects
+func probeProjectLag(target string, config HTTPProbe, client *http.Client, metrics *lagMetrics, failures *projectFailures, lastTsChan chan<- int) {
+	var events, latestTimestamp int
+	health := projectHealth{}
+	failed := 0
+	denied := false
+
+	// The stats and keys of the project are fetched concurrently.
+	var mu sync.Mutex
+	requests := config.Lag.projectRequests()
+	forEachTarget(requests, config.Lag.statsConcurrency(len(requests)), nil, func(request string) {
+		var err error
+		if request == lagRequestKeys {
+			var rates map[string]float64
+			if rates, err = requestRateLimit(target, config, client); err == nil {
+				metrics.recordRateLimits(target, rates)
+			}
+		} else {
+			var ps projectStats
+			if ps, err = requestEventCount(target, request, config, client); err == nil {
+				metrics.recordStats(target, request, ps, config.Lag.latestTimestamp())
+				if request == "received" {
+					mu.Lock()
+					events, latestTimestamp = ps.events, ps.latestTimestamp
+					mu.Unlock()
+				}
+			}
+		}
+		if err == nil {
+			return
 		}
+		config.logger().Error(err)
+		mu.Lock()
+		defer mu.Unlock()
+		if request == "received" && isAccessDenied(err) {
+			denied = true
+			return
+		}
+		failed++
+	})
+	if denied {
+		config.logger().Warnf("Access denied to project %s, skipping it for %s\n", target, config.Lag.accessDeniedTTL())
+		deniedProjects.deny(config.Organization, target, config.Lag.accessDeniedTTL())
+		failures.add(target, 0)
+		lastTsChan <- 0
+		return
+	}
+	health.failed = failed > 0
+	health.events = events
+	if latestTimestamp > 0 {
+		health.lag = generateLag(latestTimestamp)
 	}
-	log.Debugf("Processed project %s\n", target)
+	failures.add(target, failed)
+	if config.plan == nil {
+		projectHistory.record(config.Organization, target, health)
+	}
+	config.logger().Debugf("Processed project %s\n", target)
 	lastTsChan <- latestTimestamp
 }
 
-func probeHTTPLag(values url.Values, w http.ResponseWriter, module Module) bool {
-	target := values.Get("target")
+func probeHTTPLag(values url.Values, registry *prometheus.Registry, module Module) bool {
+	target := targetParam(values)
 	config := module.HTTP
-	client := clientWithTimeout(values, config.Lag.Timeout)
+	client := clientWithTimeout(values, config.Lag.Timeout, config.transport)
+
+	metrics := newLagMetrics(registry)
+	if config.Lag.latestTimestamp() {
+		registry.MustRegister(metrics.latestTimestamp)
+	}
+	if config.Lag.rateLimit() {
+		registry.MustRegister(metrics.rateLimit)
+	}
 
-	failures := 0
+	discoveryFailures := 0
+	failures := newProjectFailures()
 
 	var targets []string
-	if target != "" {
-		targets = append(targets, target)
-	} else {
-		targets = getOrUpdateProjectsList(config, client, &failures, w)
+	for _, t := range resolveTargets(target, config, client, &discoveryFailures, registry) {
+		if !deniedProjects.denied(config.Organization, t) {
+			targets = append(targets, t)
+		}
+	}
+	config.logger().Infof("Processing lag probe for %d Sentry projects\n", len(targets))
+	if _, hasDeadline := config.context().Deadline(); hasDeadline || config.Lag.SoftDeadline > 0 || config.apiBudget != nil {
+		// Not every project may be fetched, start with the most important ones.
+		targets = projectHistory.prioritize(config.Organization, targets)
 	}
-	log.Infof("Processing lag probe for %d Sentry projects\n", len(targets))
 
-	var wg sync.WaitGroup
+	start := time.Now()
 	ch := make(chan int, len(targets))
-	for _, t := range targets {
-		wg.Add(1)
-		go probeProjectLag(t, config, client, w, &failures, ch, &wg)
-		time.Sleep(50 * time.Millisecond)
+	softDeadlineReached := func() bool {
+		return config.Lag.SoftDeadline > 0 && time.Since(start) >= time.Duration(config.Lag.SoftDeadline)
+	}
+	launched := forEachTarget(targets, config.maxConcurrency(), softDeadlineReached, func(t string) {
+		probeProjectLag(t, config, client, metrics, failures, ch)
+	})
+	if launched < len(targets) {
+		config.logger().Warnf("Soft deadline of %s reached, skipping %d projects\n", config.Lag.SoftDeadline, len(targets)-launched)
 	}
 
 	latestTimestamp := -1
-	for range targets {
+	for i := 0; i < launched; i++ {
 		ts := <-ch
 		if ts == 0 {
 			continue
@@ -176,14 +357,50 @@ func probeHTTPLag(values url.Values, w http.ResponseWriter, module Module) bool
 		}
 	}
 
-	wg.Wait()
 	if latestTimestamp > -1 {
-		fmt.Fprintf(w, "sentry_events_latest_timestamp %d\n", latestTimestamp)
-		fmt.Fprintf(w, "sentry_events_lag_seconds %d\n", generateLag(latestTimestamp))
+		if config.Lag.latestTimestamp() {
+			latestTimestampGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+				Name: "sentry_events_latest_timestamp",
+				Help: "Timestamp of the latest stats bucket containing events across all probed projects",
+			})
+			registry.MustRegister(latestTimestampGauge)
+			latestTimestampGauge.Set(float64(latestTimestamp))
+		}
+		lagGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "sentry_events_lag_seconds",
+			Help: "Seconds since the latest stats bucket containing events across all probed projects",
+		})
+		registry.MustRegister(lagGauge)
+		lagGauge.Set(float64(generateLag(latestTimestamp)))
+	}
+	registerFetchFailures(registry, discoveryFailures+failures.total())
+	failures.register(registry)
+	deniedGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sentry_project_access_denied",
+		Help: "Whether the project is skipped because the Sentry API denied access to its stats",
+	}, []string{"project"})
+	registry.MustRegister(deniedGauge)
+	for _, p := range deniedProjects.projects(config.Organization) {
+		deniedGauge.WithLabelValues(p).Set(1)
+	}
+	if config.Lag.SoftDeadline > 0 {
+		partialGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "sentry_probe_partial",
+			Help: "Whether the soft deadline was reached before all projects were fetched",
+		})
+		skippedGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "sentry_probe_skipped_projects",
+			Help: "Number of projects not fetched because the soft deadline was reached",
+		})
+		registry.MustRegister(partialGauge, skippedGauge)
+		skipped := len(targets) - launched
+		if skipped > 0 {
+			partialGauge.Set(1)
+		}
+		skippedGauge.Set(float64(skipped))
 	}
-	fmt.Fprintf(w, "sentry_fetch_failures %d\n", failures)
 
-	log.Infof("Processed probe with %d fetch failures\n", failures)
+	config.logger().Infof("Processed probe with %d fetch failures\n", discoveryFailures+failures.total())
 
 	return true
 }
@@ -0,0 +1,123 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type EventResponse struct {
+	EventID     string    `json:"eventID"`
+	DateCreated time.Time `json:"dateCreated"`
+}
+
+func extractLatestEvent(reader io.Reader) (time.Time, error) {
+	var events []EventResponse
+	err := decodeJSON(reader, &events)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var latest time.Time
+	for _, e := range events {
+		if e.DateCreated.After(latest) {
+			latest = e.DateCreated
+		}
+	}
+	return latest, nil
+}
+
+type latestEventMetrics struct {
+	timestamp *prometheus.GaugeVec
+	age       *prometheus.GaugeVec
+}
+
+func requestLatestEvent(target string, config HTTPProbe, client *http.Client, metrics *latestEventMetrics) (time.Time, error) {
+	resp, err := requestSentry("projects/"+config.Organization+"/"+target+"/events/?full=false&limit=1", config, client)
+
+	var latest time.Time
+	if err == nil {
+		defer resp.Body.Close()
+		latest, err = extractLatestEvent(resp.Body)
+		if err == nil && !latest.IsZero() {
+			metrics.timestamp.WithLabelValues(target).Set(float64(latest.Unix()))
+			metrics.age.WithLabelValues(target).Set(time.Since(latest).Seconds())
+		}
+	}
+	if err != nil {
+		config.logger().Error(err)
+	}
+	return latest, err
+}
+
+// probeHTTPLatestEvent records Prometheus metrics on the timestamp and age of the
+// most recent event of each Sentry project, as reported by the events API
+func probeHTTPLatestEvent(values url.Values, registry *prometheus.Registry, module Module) bool {
+	target := targetParam(values)
+	config := module.HTTP
+	client := clientWithTimeout(values, config.LatestEvent.Timeout, config.transport)
+
+	metrics := &latestEventMetrics{
+		timestamp: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "sentry_project_latest_event_timestamp",
+			Help: "Timestamp of the most recent event of the project",
+		}, []string{"project"}),
+		age: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "sentry_project_latest_event_age_seconds",
+			Help: "Seconds since the most recent event of the project",
+		}, []string{"project"}),
+	}
+	registry.MustRegister(metrics.timestamp, metrics.age)
+
+	failures := 0
+
+	targets := resolveTargets(target, config, client, &failures, registry)
+	config.logger().Infof("Processing latest event probe for %d Sentry projects\n", len(targets))
+
+	var mu sync.Mutex
+	var latest time.Time
+	forEachTarget(targets, config.maxConcurrency(), nil, func(t string) {
+		ts, err := requestLatestEvent(t, config, client, metrics)
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			failures++
+		} else if ts.After(latest) {
+			latest = ts
+		}
+	})
+
+	if !latest.IsZero() {
+		timestampGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "sentry_latest_event_timestamp",
+			Help: "Timestamp of the most recent event across all probed projects",
+		})
+		ageGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "sentry_latest_event_age_seconds",
+			Help: "Seconds since the most recent event across all probed projects",
+		})
+		registry.MustRegister(timestampGauge, ageGauge)
+		timestampGauge.Set(float64(latest.Unix()))
+		ageGauge.Set(time.Since(latest).Seconds())
+	}
+	registerFetchFailures(registry, failures)
+
+	config.logger().Infof("Processed latest event probe with %d fetch failures\n", failures)
+
+	return true
+}
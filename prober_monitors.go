@@ -0,0 +1,115 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Statuses of a monitor or of one of its environments reported by Sentry.
+var monitorStatuses = []string{"active", "disabled", "ok", "error", "missed_checkin", "timeout"}
+
+type MonitorCheckIns struct {
+	Status      string     `json:"status"`
+	LastCheckIn *time.Time `json:"lastCheckIn"`
+	NextCheckIn *time.Time `json:"nextCheckIn"`
+}
+
+type MonitorEnvironment struct {
+	MonitorCheckIns
+	Name string `json:"name"`
+}
+
+// MonitorResponse is a Sentry Crons monitor. Recent Sentry versions report
+// the check-ins per environment, older ones on the monitor itself.
+type MonitorResponse struct {
+	MonitorCheckIns
+	Slug         string               `json:"slug"`
+	Environments []MonitorEnvironment `json:"environments"`
+}
+
+func extractMonitors(reader io.Reader) ([]MonitorResponse, error) {
+	var monitors []MonitorResponse
+	err := decodeJSON(reader, &monitors)
+	return monitors, err
+}
+
+// probeHTTPMonitors records Prometheus metrics on the status and check-ins of
+// every Sentry Crons monitor of the organization
+func probeHTTPMonitors(values url.Values, registry *prometheus.Registry, module Module) bool {
+	config := module.HTTP
+	client := clientWithTimeout(values, config.Monitors.Timeout, config.transport)
+
+	statusGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sentry_monitor_status",
+		Help: "Whether the monitor is in the given status",
+	}, []string{"monitor", "environment", "status"})
+	lastCheckInGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sentry_monitor_last_checkin_timestamp",
+		Help: "Timestamp of the last check-in of the monitor",
+	}, []string{"monitor", "environment"})
+	nextCheckInGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sentry_monitor_next_checkin_seconds",
+		Help: "Seconds until the next expected check-in of the monitor, negative once it is overdue",
+	}, []string{"monitor", "environment"})
+	registry.MustRegister(statusGauge, lastCheckInGauge, nextCheckInGauge)
+
+	record := func(monitor, environment string, c MonitorCheckIns) {
+		for _, s := range monitorStatuses {
+			statusGauge.WithLabelValues(monitor, environment, s)
+		}
+		if c.Status != "" {
+			statusGauge.WithLabelValues(monitor, environment, c.Status).Set(1)
+		}
+		if c.LastCheckIn != nil {
+			lastCheckInGauge.WithLabelValues(monitor, environment).Set(float64(c.LastCheckIn.Unix()))
+		}
+		if c.NextCheckIn != nil {
+			nextCheckInGauge.WithLabelValues(monitor, environment).Set(time.Until(*c.NextCheckIn).Seconds())
+		}
+	}
+
+	config.logger().Infof("Processing monitors probe\n")
+
+	failures := 0
+	count := 0
+	err := requestSentryPages("organizations/"+config.Organization+"/monitors/", maxSentryPages, config, client, func(reader io.Reader) error {
+		monitors, err := extractMonitors(reader)
+		if err != nil {
+			return err
+		}
+		for _, m := range monitors {
+			count++
+			if len(m.Environments) == 0 {
+				record(m.Slug, "", m.MonitorCheckIns)
+			}
+			for _, e := range m.Environments {
+				record(m.Slug, e.Name, e.MonitorCheckIns)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		config.logger().Error(err)
+		failures++
+	}
+	registerFetchFailures(registry, failures)
+
+	config.logger().Infof("Processed monitors probe for %d monitors with %d fetch failures\n", count, failures)
+
+	return true
+}
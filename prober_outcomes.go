@@ -0,0 +1,67 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// probeHTTPOutcomes records Prometheus metrics on the outcomes (accepted, filtered,
+// rate_limited, invalid, abuse...) of the events of the projects of the target,
+// using a single request to the stats_v2 API
+func probeHTTPOutcomes(values url.Values, registry *prometheus.Registry, module Module) bool {
+	config := module.HTTP
+	client := clientWithTimeout(values, config.Outcomes.Timeout, config.transport)
+
+	period, err := statsPeriod(values, config.Outcomes.Period, time.Hour)
+	if err != nil {
+		config.logger().Error(err)
+		return false
+	}
+	categories := config.Outcomes.Categories
+	if len(categories) == 0 {
+		categories = []string{"error"}
+	}
+
+	outcomesGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sentry_project_outcomes_total",
+		Help: "Number of items of the project over the period, by category and outcome",
+	}, []string{"project", "category", "outcome"})
+	registry.MustRegister(outcomesGauge)
+
+	config.logger().Infof("Processing outcomes probe for period %s\n", period)
+
+	failures := 0
+	target := targetParam(values)
+	groups, err := requestOrgStats(target, categories, period, config, client, &failures, registry)
+	if err == errNoMatchingProject {
+		config.logger().Errorf("No project of the organization matches the target %q", target)
+		registerFetchFailures(registry, failures)
+		return false
+	}
+	if err != nil {
+		config.logger().Error(err)
+		failures++
+	}
+	for _, group := range groups {
+		outcomesGauge.WithLabelValues(group.project, group.category, group.outcome).Set(group.quantity)
+	}
+	registerFetchFailures(registry, failures)
+
+	config.logger().Infof("Processed outcomes probe with %d fetch failures\n", failures)
+
+	return true
+}
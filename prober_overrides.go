@@ -0,0 +1,52 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// overriddenProber returns the prober making its requests with the
+// valid_status_codes and follow_redirects of its prober_overrides, if any.
+func overriddenProber(name string, prober ProbeFn, overrides map[string]ProberOverrides) ProbeFn {
+	o, ok := overrides[name]
+	if !ok {
+		return prober
+	}
+	return func(values url.Values, registry *prometheus.Registry, module Module) bool {
+		if o.ValidStatusCodes != nil {
+			module.HTTP.ValidStatusCodes = o.ValidStatusCodes
+		}
+		if o.FollowRedirects != nil {
+			module.HTTP.FollowRedirects = o.FollowRedirects
+		}
+		return prober(values, registry, module)
+	}
+}
+
+func (p HTTPProbe) followsRedirects() bool {
+	return p.FollowRedirects == nil || *p.FollowRedirects
+}
+
+// withoutRedirects returns a copy of the client returning redirects as the
+// response instead of following them.
+func withoutRedirects(client *http.Client) *http.Client {
+	c := *client
+	c.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return &c
+}
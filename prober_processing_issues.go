@@ -0,0 +1,101 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type ProcessingIssuesResponse struct {
+	NumIssues         int `json:"numIssues"`
+	ResolveableIssues int `json:"resolveableIssues"`
+	IssuesProcessing  int `json:"issuesProcessing"`
+}
+
+func extractProcessingIssues(reader io.Reader) (ProcessingIssuesResponse, error) {
+	var issues ProcessingIssuesResponse
+	err := decodeJSON(reader, &issues)
+	return issues, err
+}
+
+type processingIssuesMetrics struct {
+	issues      *prometheus.GaugeVec
+	resolveable *prometheus.GaugeVec
+	processing  *prometheus.GaugeVec
+}
+
+func requestProcessingIssues(target string, config HTTPProbe, client *http.Client, metrics *processingIssuesMetrics) error {
+	resp, err := requestSentry("projects/"+config.Organization+"/"+target+"/processing-issues/", config, client)
+	if err == nil {
+		defer resp.Body.Close()
+		var issues ProcessingIssuesResponse
+		issues, err = extractProcessingIssues(resp.Body)
+		if err == nil {
+			metrics.issues.WithLabelValues(target).Set(float64(issues.NumIssues))
+			metrics.resolveable.WithLabelValues(target).Set(float64(issues.ResolveableIssues))
+			metrics.processing.WithLabelValues(target).Set(float64(issues.IssuesProcessing))
+		}
+	}
+	if err != nil {
+		config.logger().Error(err)
+	}
+	return err
+}
+
+// probeHTTPProcessingIssues records Prometheus metrics on the processing issues
+// of each Sentry project, such as missing debug files, which hold back events
+func probeHTTPProcessingIssues(values url.Values, registry *prometheus.Registry, module Module) bool {
+	target := targetParam(values)
+	config := module.HTTP
+	client := clientWithTimeout(values, config.ProcessingIssues.Timeout, config.transport)
+
+	metrics := &processingIssuesMetrics{
+		issues: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "sentry_project_processing_issues",
+			Help: "Number of processing issues of the project, such as missing debug files",
+		}, []string{"project"}),
+		resolveable: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "sentry_project_processing_issues_resolveable",
+			Help: "Number of events of the project which can be reprocessed once its processing issues are resolved",
+		}, []string{"project"}),
+		processing: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "sentry_project_processing_issues_reprocessing",
+			Help: "Number of events of the project currently being reprocessed",
+		}, []string{"project"}),
+	}
+	registry.MustRegister(metrics.issues, metrics.resolveable, metrics.processing)
+
+	failures := 0
+
+	targets := resolveTargets(target, config, client, &failures, registry)
+	config.logger().Infof("Processing processing issues probe for %d Sentry projects\n", len(targets))
+
+	var mu sync.Mutex
+	forEachTarget(targets, config.maxConcurrency(), nil, func(t string) {
+		if err := requestProcessingIssues(t, config, client, metrics); err != nil {
+			mu.Lock()
+			failures++
+			mu.Unlock()
+		}
+	})
+	registerFetchFailures(registry, failures)
+
+	config.logger().Infof("Processed processing issues probe with %d fetch failures\n", failures)
+
+	return true
+}
@@ -0,0 +1,223 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type ReleaseResponse struct {
+	Version     string    `json:"version"`
+	DateCreated time.Time `json:"dateCreated"`
+}
+
+type DeployResponse struct {
+	Environment  string    `json:"environment"`
+	DateFinished time.Time `json:"dateFinished"`
+}
+
+func extractLatestRelease(reader io.Reader) (ReleaseResponse, error) {
+	var releases []ReleaseResponse
+	var latest ReleaseResponse
+	err := decodeJSON(reader, &releases)
+	if err != nil {
+		return latest, err
+	}
+	for _, r := range releases {
+		if r.DateCreated.After(latest.DateCreated) {
+			latest = r
+		}
+	}
+	return latest, nil
+}
+
+func extractDeploys(reader io.Reader) ([]DeployResponse, error) {
+	var deploys []DeployResponse
+	err := decodeJSON(reader, &deploys)
+	return deploys, err
+}
+
+type EnvironmentResponse struct {
+	Name string `json:"name"`
+}
+
+func extractEnvironments(reader io.Reader) ([]EnvironmentResponse, error) {
+	var environments []EnvironmentResponse
+	err := decodeJSON(reader, &environments)
+	return environments, err
+}
+
+type releaseMetrics struct {
+	latestCreated *prometheus.GaugeVec
+	age           *prometheus.GaugeVec
+	deploys       *prometheus.GaugeVec
+}
+
+// projectEnvironments returns the environments whose releases are probed for
+// the project: the configured ones, or else those of the project. A project
+// without any environment has its releases probed regardless of their
+// environment, with an empty environment label.
+func projectEnvironments(target string, config HTTPProbe, client *http.Client) ([]string, error) {
+	if len(config.Releases.Environments) > 0 {
+		return config.Releases.Environments, nil
+	}
+	resp, err := requestSentry("projects/"+config.Organization+"/"+target+"/environments/", config, client)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	environments, err := extractEnvironments(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{""}
+	if len(environments) > 0 {
+		names = names[:0]
+		for _, e := range environments {
+			names = append(names, e.Name)
+		}
+	}
+	return names, nil
+}
+
+// requestProjectRelease records the latest release of the project in each of
+// its environments and the number of deploys of that release to the
+// environment.
+func requestProjectRelease(target, projectID string, config HTTPProbe, client *http.Client, metrics *releaseMetrics) error {
+	environments, err := projectEnvironments(target, config, client)
+	if err != nil {
+		config.logger().Error(err)
+		return err
+	}
+	// Environments sharing their latest release share its deploys.
+	deploysByVersion := make(map[string][]DeployResponse)
+	for _, environment := range environments {
+		query := url.Values{}
+		query.Set("project", projectID)
+		if environment != "" {
+			query.Set("environment", environment)
+		}
+		resp, err := requestSentry("organizations/"+config.Organization+"/releases/?"+query.Encode(), config, client)
+		if err != nil {
+			config.logger().Error(err)
+			return err
+		}
+		release, err := extractLatestRelease(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			config.logger().Error(err)
+			return err
+		}
+		if release.Version == "" {
+			config.logger().Debugf("No release found for project %s in environment %q\n", target, environment)
+			continue
+		}
+		metrics.latestCreated.WithLabelValues(target, environment).Set(float64(release.DateCreated.Unix()))
+		metrics.age.WithLabelValues(target, environment).Set(time.Since(release.DateCreated).Seconds())
+
+		deploys, ok := deploysByVersion[release.Version]
+		if !ok {
+			resp, err := requestSentry("organizations/"+config.Organization+"/releases/"+url.PathEscape(release.Version)+"/deploys/", config, client)
+			if err != nil {
+				config.logger().Error(err)
+				return err
+			}
+			deploys, err = extractDeploys(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				config.logger().Error(err)
+				return err
+			}
+			deploysByVersion[release.Version] = deploys
+		}
+		count := 0
+		for _, d := range deploys {
+			if environment == "" || d.Environment == environment {
+				count++
+			}
+		}
+		metrics.deploys.WithLabelValues(target, environment).Set(float64(count))
+	}
+	return nil
+}
+
+// probeHTTPReleases records Prometheus metrics on the latest release of each
+// Sentry project in each of its environments and its deploys
+func probeHTTPReleases(values url.Values, registry *prometheus.Registry, module Module) bool {
+	target := targetParam(values)
+	config := module.HTTP
+	client := clientWithTimeout(values, config.Releases.Timeout, config.transport)
+
+	metrics := &releaseMetrics{
+		latestCreated: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "sentry_release_latest_created_timestamp",
+			Help: "Creation timestamp of the latest release of the project in the environment",
+		}, []string{"project", "environment"}),
+		age: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "sentry_release_age_seconds",
+			Help: "Seconds since the latest release of the project in the environment was created",
+		}, []string{"project", "environment"}),
+		deploys: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "sentry_release_deploys_total",
+			Help: "Number of deploys to the environment of the latest release of the project in the environment",
+		}, []string{"project", "environment"}),
+	}
+	registry.MustRegister(metrics.latestCreated, metrics.age, metrics.deploys)
+
+	failures := 0
+
+	targets := resolveTargets(target, config, client, &failures, registry)
+	config.logger().Infof("Processing releases probe for %d Sentry projects\n", len(targets))
+
+	// The releases of the organization are filtered by project ID.
+	projectIDs := make(map[string]string)
+	if len(targets) > 0 {
+		slugs, err := sentryProjectSlugs(config, client)
+		if err != nil {
+			config.logger().Error(err)
+			failures++
+			targets = nil
+		}
+		for id, slug := range slugs {
+			projectIDs[slug] = id
+		}
+	}
+
+	var mu sync.Mutex
+	forEachTarget(targets, config.maxConcurrency(), nil, func(t string) {
+		id, ok := projectIDs[t]
+		if !ok {
+			config.logger().Errorf("Project %s not found in Sentry organization %s", t, config.Organization)
+			mu.Lock()
+			failures++
+			mu.Unlock()
+			return
+		}
+		if err := requestProjectRelease(t, id, config, client, metrics); err != nil {
+			mu.Lock()
+			failures++
+			mu.Unlock()
+		}
+	})
+	registerFetchFailures(registry, failures)
+
+	config.logger().Infof("Processed releases probe with %d fetch failures\n", failures)
+
+	return true
+}
@@ -0,0 +1,102 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestProbeHTTPReleasesPerEnvironment(t *testing.T) {
+	production := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	staging := time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body interface{}
+		switch r.URL.Path {
+		case "/api/0/organizations/o/projects/":
+			body = []map[string]string{{"id": "1", "slug": "a"}}
+		case "/api/0/projects/o/a/environments/":
+			body = []map[string]string{{"name": "production"}, {"name": "staging"}}
+		case "/api/0/organizations/o/releases/":
+			if r.URL.Query().Get("project") != "1" {
+				t.Errorf("releases requested for project %q, want 1", r.URL.Query().Get("project"))
+			}
+			switch r.URL.Query().Get("environment") {
+			case "production":
+				body = []map[string]interface{}{
+					{"version": "1.0.0", "dateCreated": production.Add(-time.Hour)},
+					{"version": "1.1.0", "dateCreated": production},
+				}
+			case "staging":
+				body = []map[string]interface{}{{"version": "1.2.0", "dateCreated": staging}}
+			default:
+				t.Errorf("releases requested without an environment")
+				body = []interface{}{}
+			}
+		case "/api/0/organizations/o/releases/1.1.0/deploys/":
+			body = []map[string]string{{"environment": "production"}, {"environment": "production"}}
+		case "/api/0/organizations/o/releases/1.2.0/deploys/":
+			body = []map[string]string{{"environment": "staging"}}
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(body)
+	}))
+	defer server.Close()
+
+	registry := prometheus.NewRegistry()
+	module := Module{HTTP: HTTPProbe{Domain: server.URL, Organization: "o"}}
+	if !probeHTTPReleases(url.Values{"target": {"a"}}, registry, module) {
+		t.Fatal("releases probe failed")
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]float64)
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			key := mf.GetName()
+			for _, l := range m.GetLabel() {
+				key += " " + l.GetName() + "=" + l.GetValue()
+			}
+			got[key] = m.GetGauge().GetValue()
+		}
+	}
+
+	want := map[string]float64{
+		"sentry_release_latest_created_timestamp environment=production project=a": float64(production.Unix()),
+		"sentry_release_latest_created_timestamp environment=staging project=a":    float64(staging.Unix()),
+		"sentry_release_deploys_total environment=production project=a":            2,
+		"sentry_release_deploys_total environment=staging project=a":               1,
+		"sentry_fetch_failures": 0,
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("%s = %v, want %v", key, got[key], value)
+		}
+	}
+	for _, environment := range []string{"production", "staging"} {
+		key := "sentry_release_age_seconds environment=" + environment + " project=a"
+		if _, ok := got[key]; !ok {
+			t.Errorf("%s missing", key)
+		}
+	}
+}
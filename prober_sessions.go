@@ -0,0 +1,137 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// SessionsResponse is the grouped response of the sessions and stats_v2 APIs.
+type SessionsResponse struct {
+	Groups []SessionsGroup `json:"groups"`
+}
+
+type SessionsGroup struct {
+	By     map[string]interface{} `json:"by"`
+	Totals map[string]*float64    `json:"totals"`
+}
+
+func extractSessions(reader io.Reader) (SessionsResponse, error) {
+	var sessions SessionsResponse
+	// Project IDs are numbers, which must not be formatted as floats.
+	decoder := json.NewDecoder(reader)
+	decoder.UseNumber()
+	err := decoder.Decode(&sessions)
+	return sessions, err
+}
+
+var sessionsFields = []string{
+	"sum(session)",
+	"count_unique(user)",
+	"crash_free_rate(session)",
+	"crash_free_rate(user)",
+}
+
+// probeHTTPSessions records Prometheus metrics on the release health of each
+// Sentry project and release: session and user counts along with crash free rates
+func probeHTTPSessions(values url.Values, registry *prometheus.Registry, module Module) bool {
+	config := module.HTTP
+	client := clientWithTimeout(values, config.Sessions.Timeout, config.transport)
+
+	period, err := statsPeriod(values, config.Sessions.Period, 24*time.Hour)
+	if err != nil {
+		config.logger().Error(err)
+		return false
+	}
+
+	labels := []string{"project", "release"}
+	gauges := map[string]*prometheus.GaugeVec{
+		"sum(session)": prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "sentry_sessions_total",
+			Help: "Number of sessions of the release over the period",
+		}, labels),
+		"count_unique(user)": prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "sentry_session_users_total",
+			Help: "Number of distinct users with sessions of the release over the period",
+		}, labels),
+		"crash_free_rate(session)": prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "sentry_crash_free_sessions_ratio",
+			Help: "Ratio of sessions of the release which did not crash over the period",
+		}, labels),
+		"crash_free_rate(user)": prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "sentry_crash_free_users_ratio",
+			Help: "Ratio of users of the release who did not experience a crash over the period",
+		}, labels),
+	}
+	for _, g := range gauges {
+		registry.MustRegister(g)
+	}
+
+	config.logger().Infof("Processing sessions probe for period %s\n", period)
+
+	failures := 0
+	target := targetParam(values)
+	slugs, all := resolveProjectIDs(target, config, client, &failures, registry)
+	if len(slugs) == 0 {
+		// Without a project parameter Sentry would answer for the whole
+		// organization.
+		config.logger().Errorf("No project of the organization matches the target %q", target)
+		registerFetchFailures(registry, failures)
+		return false
+	}
+
+	query := url.Values{}
+	for _, field := range sessionsFields {
+		query.Add("field", field)
+	}
+	query.Add("groupBy", "project")
+	query.Add("groupBy", "release")
+	query.Set("statsPeriod", period)
+	query.Set("interval", period)
+	addProjectIDs(query, slugs, all)
+
+	resp, err := requestSentry("organizations/"+config.Organization+"/sessions/?"+query.Encode(), config, client)
+	if err == nil {
+		defer resp.Body.Close()
+		var sessions SessionsResponse
+		sessions, err = extractSessions(resp.Body)
+		for _, group := range sessions.Groups {
+			projectID := fmt.Sprint(group.By["project"])
+			project, ok := slugs[projectID]
+			if !ok {
+				project = projectID
+			}
+			release := fmt.Sprint(group.By["release"])
+			for field, value := range group.Totals {
+				if g, ok := gauges[field]; ok && value != nil {
+					g.WithLabelValues(project, release).Set(*value)
+				}
+			}
+		}
+	}
+	if err != nil {
+		config.logger().Error(err)
+		failures++
+	}
+	registerFetchFailures(registry, failures)
+
+	config.logger().Infof("Processed sessions probe with %d fetch failures\n", failures)
+
+	return true
+}
@@ -0,0 +1,162 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type ProjectTeamsResponse struct {
+	Slug  string         `json:"slug"`
+	Team  *TeamResponse  `json:"team"`
+	Teams []TeamResponse `json:"teams"`
+}
+
+// teamSlugs returns the slugs of the teams of the project, from its teams or
+// else from its legacy team field.
+func (p ProjectTeamsResponse) teamSlugs() []string {
+	var slugs []string
+	for _, t := range p.Teams {
+		slugs = append(slugs, t.Slug)
+	}
+	if len(slugs) == 0 && p.Team != nil {
+		slugs = append(slugs, p.Team.Slug)
+	}
+	return slugs
+}
+
+func extractProjectTeams(reader io.Reader) ([]ProjectTeamsResponse, error) {
+	var projects []ProjectTeamsResponse
+	err := decodeJSON(reader, &projects)
+	return projects, err
+}
+
+// getProjectTeams returns the slugs of the teams of the projects of the
+// organization by project slug, from the module's project cache if any.
+func getProjectTeams(config HTTPProbe, client *http.Client, failures *int) map[string][]string {
+	if config.projectCache != nil {
+		return config.projectCache.teams(config, client, failures)
+	}
+	_, teams, err := allSentryProjects(config, client)
+	if err != nil {
+		config.logger().Error(err)
+		*failures++
+	}
+	return teams
+}
+
+// probeHTTPTeams records Prometheus metrics on the projects of every team:
+// their events over the last hour, the lag of the latest of them and their
+// high-frequency issues, selected like those of the issues prober. A project
+// counts towards each of its teams, and projects without a team towards the
+// team "".
+func probeHTTPTeams(values url.Values, registry *prometheus.Registry, module Module) bool {
+	config := module.HTTP
+	client := clientWithTimeout(values, config.Teams.Timeout, config.transport)
+
+	above, period, query, err := issuesParams(values, config)
+	if err != nil {
+		config.logger().Error(err)
+		return false
+	}
+
+	projectsGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sentry_team_projects",
+		Help: "Number of probed projects of the team",
+	}, []string{"team"})
+	eventsGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sentry_team_events_1h_total",
+		Help: "Number of events received by the projects of the team over the last hour",
+	}, []string{"team"})
+	lagGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sentry_team_lag_seconds",
+		Help: "Seconds since the latest stats bucket containing events across the projects of the team",
+	}, []string{"team"})
+	issuesGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sentry_team_project_high_freq_issues",
+		Help: "Number of issues of the projects of the team matching the query with more events than the threshold over the period",
+	}, []string{"team", "above", "period"})
+	registry.MustRegister(projectsGauge, eventsGauge, lagGauge, issuesGauge)
+
+	failures := 0
+	targets := resolveTargets(targetParam(values), config, client, &failures, registry)
+	// Cached along with the projects discovered to resolve the targets.
+	teams := getProjectTeams(config, client, &failures)
+
+	config.logger().Infof("Processing teams probe for %d Sentry projects\n", len(targets))
+
+	var mu sync.Mutex
+	latest := make(map[string]int)
+	forEachTarget(targets, config.maxConcurrency(), nil, func(t string) {
+		ps, err := requestEventCount(t, "received", config, client)
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			config.logger().Error(err)
+			failures++
+			return
+		}
+		for _, team := range projectTeams(teams, t) {
+			eventsGauge.WithLabelValues(team).Add(float64(ps.events))
+			if ps.latestTimestamp > latest[team] {
+				latest[team] = ps.latestTimestamp
+			}
+		}
+	})
+	for _, t := range targets {
+		for _, team := range projectTeams(teams, t) {
+			projectsGauge.WithLabelValues(team).Inc()
+		}
+	}
+	for team, ts := range latest {
+		lagGauge.WithLabelValues(team).Set(float64(generateLag(ts)))
+	}
+
+	var deadline time.Time
+	if config.Issues.Deadline > 0 {
+		deadline = time.Now().Add(time.Duration(config.Issues.Deadline))
+	}
+	issues := requestHighFreqIssues(above, period, query, config.deadline(deadline), config, client)
+	probed := make(map[string]bool, len(targets))
+	for _, t := range targets {
+		probed[t] = true
+	}
+	for project, count := range issues.perProject {
+		if !probed[project] {
+			continue
+		}
+		for _, team := range projectTeams(teams, project) {
+			issuesGauge.WithLabelValues(team, strconv.Itoa(above), period).Add(float64(count))
+		}
+	}
+	registerFetchFailures(registry, failures)
+
+	config.logger().Infof("Processed teams probe with %d fetch failures\n", failures)
+
+	return true
+}
+
+// projectTeams returns the teams the project counts towards.
+func projectTeams(teams map[string][]string, project string) []string {
+	if t := teams[project]; len(t) > 0 {
+		return t
+	}
+	return []string{""}
+}
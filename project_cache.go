@@ -0,0 +1,138 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Default time for which the projects of an organization are cached.
+const defaultProjectsCacheTTL = 10 * time.Minute
+
+var (
+	projectCacheHitsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "sentry_exporter_project_cache_hits_total",
+		Help: "Number of probes which used the cached projects list of the module",
+	}, []string{"module"})
+	projectCacheMissesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "sentry_exporter_project_cache_misses_total",
+		Help: "Number of probes which fetched the projects list of the module from the Sentry API",
+	}, []string{"module"})
+)
+
+func init() {
+	prometheus.MustRegister(projectCacheHitsTotal, projectCacheMissesTotal)
+}
+
+func (f ProjectFilter) cacheTTL() time.Duration {
+	if f.CacheTTL > 0 {
+		return time.Duration(f.CacheTTL)
+	}
+	return defaultProjectsCacheTTL
+}
+
+type cachedProjects struct {
+	projects []string
+	// The slugs of the teams of each project, by project slug.
+	teams   map[string][]string
+	fetched time.Time
+}
+
+// ProjectCache holds the projects of the organizations probed by a module,
+// since a single module may probe several organizations. Lists expire after
+// the TTL, and empty lists are never cached. A failed fetch keeps the list
+// cached before, expired or not.
+type ProjectCache struct {
+	mu       sync.Mutex
+	module   string
+	ttl      time.Duration
+	projects map[string]cachedProjects
+}
+
+func newProjectCache(module string, ttl time.Duration) *ProjectCache {
+	return &ProjectCache{
+		module:   module,
+		ttl:      ttl,
+		projects: make(map[string]cachedProjects),
+	}
+}
+
+// get returns the cached projects of the organization of the config,
+// fetching them when missing, expired or when the probe forces a refresh.
+// Concurrent probes of the module wait for a single fetch.
+func (c *ProjectCache) get(config HTTPProbe, client *http.Client, failures *int, registry *prometheus.Registry) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	org := config.Organization
+	if cached, ok := c.projects[org]; ok && !config.refreshProjects && time.Since(cached.fetched) < c.ttl {
+		projectCacheHitsTotal.WithLabelValues(c.module).Inc()
+		return cached.projects
+	}
+	projectCacheMissesTotal.WithLabelValues(c.module).Inc()
+	projects, teams, ok := discoverProjects(config, client, failures, registry)
+	if !ok {
+		// A failed fetch keeps the previous list, which is complete.
+		if cached, found := c.projects[org]; found {
+			config.logger().Warnf("Probing the projects of organization %s fetched %s ago", org, time.Since(cached.fetched).Round(time.Second))
+			return cached.projects
+		}
+		return nil
+	}
+	if len(projects) == 0 {
+		delete(c.projects, org)
+	} else {
+		c.projects[org] = cachedProjects{projects: projects, teams: teams, fetched: time.Now()}
+	}
+	return projects
+}
+
+// teams returns the teams of the cached projects of the organization of the
+// config by project slug, fetching the projects when missing or expired,
+// without exporting their number again. A probe forcing a refresh has
+// already fetched them when resolving its targets.
+func (c *ProjectCache) teams(config HTTPProbe, client *http.Client, failures *int) map[string][]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	org := config.Organization
+	cached, found := c.projects[org]
+	if found && time.Since(cached.fetched) < c.ttl {
+		projectCacheHitsTotal.WithLabelValues(c.module).Inc()
+		return cached.teams
+	}
+	projectCacheMissesTotal.WithLabelValues(c.module).Inc()
+	projects, teams, err := allSentryProjects(config, client)
+	if err != nil {
+		config.logger().Error(err)
+		*failures++
+		return cached.teams
+	}
+	if len(projects) > 0 {
+		c.projects[org] = cachedProjects{projects: projects, teams: teams, fetched: time.Now()}
+	}
+	return teams
+}
+
+// cached returns the cached projects of the organization, expired or not,
+// without fetching them.
+func (c *ProjectCache) cached(org string) ([]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cached, ok := c.projects[org]
+	return cached.projects, ok
+}
@@ -0,0 +1,108 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+)
+
+// MetricRelabel renames or drops the metrics of a probe before they are
+// exposed. Metric is a regular expression matched against the whole metric
+// name. The drop action drops the matching metrics, rename renames them to
+// NewName and drop_label removes Label from them.
+type MetricRelabel struct {
+	Metric  string `yaml:"metric"`
+	Action  string `yaml:"action"`
+	NewName string `yaml:"new_name"`
+	Label   string `yaml:"label"`
+}
+
+func (r MetricRelabel) validate() error {
+	if _, err := regexp.Compile(r.Metric); err != nil {
+		return fmt.Errorf("metric relabel has invalid metric %q: %s", r.Metric, err)
+	}
+	switch r.Action {
+	case "drop":
+	case "rename":
+		if !model.IsValidMetricName(model.LabelValue(r.NewName)) {
+			return fmt.Errorf("metric relabel of %q has invalid new_name %q", r.Metric, r.NewName)
+		}
+	case "drop_label":
+		if r.Label == "" {
+			return fmt.Errorf("metric relabel of %q is missing a label", r.Metric)
+		}
+	default:
+		return fmt.Errorf("metric relabel of %q has unknown action %q", r.Metric, r.Action)
+	}
+	return nil
+}
+
+// relabelRegistry applies the relabel rules, in order, to the metrics of
+// the registry and returns a registry exposing the result.
+func relabelRegistry(registry *prometheus.Registry, rules []MetricRelabel, logger *Logger) *prometheus.Registry {
+	if len(rules) == 0 {
+		return registry
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		logger.Errorf("Error gathering probe metrics for relabeling: %s", err)
+	}
+
+	for _, r := range rules {
+		re := regexp.MustCompile("^(?:" + r.Metric + ")$")
+		var kept []*dto.MetricFamily
+		for _, mf := range families {
+			if !re.MatchString(mf.GetName()) {
+				kept = append(kept, mf)
+				continue
+			}
+			switch r.Action {
+			case "drop":
+				continue
+			case "rename":
+				mf.Name = proto.String(r.NewName)
+			case "drop_label":
+				for _, m := range mf.Metric {
+					var labels []*dto.LabelPair
+					for _, lp := range m.Label {
+						if lp.GetName() != r.Label {
+							labels = append(labels, lp)
+						}
+					}
+					m.Label = labels
+				}
+			}
+			kept = append(kept, mf)
+		}
+		families = kept
+	}
+
+	relabeled := make(familiesCollector)
+	for _, mf := range families {
+		if out, ok := relabeled[mf.GetName()]; ok {
+			// Renamed onto an existing metric.
+			out.Metric = append(out.Metric, mf.Metric...)
+			continue
+		}
+		relabeled[mf.GetName()] = mf
+	}
+	out := prometheus.NewRegistry()
+	out.MustRegister(relabeled)
+	return out
+}
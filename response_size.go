@@ -0,0 +1,104 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// Default of the largest response body of the Sentry API read by a
+	// probe.
+	defaultMaxResponseBytes = 64 << 20
+	// Largest rest of a response body read when closing it, larger rests
+	// closing the connection instead.
+	maxDiscardedBytes = 4 << 10
+)
+
+var apiResponseBytesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "sentry_exporter_api_response_bytes_total",
+	Help: "Number of bytes read from the response bodies of the Sentry API",
+}, []string{"endpoint"})
+
+func init() {
+	prometheus.MustRegister(apiResponseBytesTotal)
+}
+
+func (p HTTPProbe) maxResponseBytes() int64 {
+	if p.MaxResponseBytes > 0 {
+		return p.MaxResponseBytes
+	}
+	return defaultMaxResponseBytes
+}
+
+// responseTooLargeError is returned when reading a response body of the
+// Sentry API beyond max_response_bytes.
+type responseTooLargeError struct {
+	endpoint string
+	limit    int64
+}
+
+func (e *responseTooLargeError) Error() string {
+	return fmt.Sprintf("response of %s larger than max_response_bytes %d", e.endpoint, e.limit)
+}
+
+// responseBody counts the bytes read from a response body of the Sentry
+// API, and fails once more than limit bytes were read.
+type responseBody struct {
+	io.ReadCloser
+	endpoint string
+	limit    int64
+	read     int64
+}
+
+func newResponseBody(body io.ReadCloser, endpoint string, limit int64) *responseBody {
+	return &responseBody{ReadCloser: body, endpoint: endpoint, limit: limit}
+}
+
+func (b *responseBody) Read(p []byte) (int, error) {
+	if b.read >= b.limit {
+		// Reading one more byte tells a body of exactly limit bytes from a
+		// larger one.
+		var extra [1]byte
+		n, err := b.ReadCloser.Read(extra[:])
+		if n > 0 {
+			return 0, &responseTooLargeError{endpoint: b.endpoint, limit: b.limit}
+		}
+		return 0, err
+	}
+	if remaining := b.limit - b.read; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	apiResponseBytesTotal.WithLabelValues(b.endpoint).Add(float64(n))
+	return n, err
+}
+
+// Close discards the rest of a small body left unread, such as the newline
+// after the JSON document, so that the connection can be reused.
+func (b *responseBody) Close() error {
+	io.Copy(ioutil.Discard, io.LimitReader(b.ReadCloser, maxDiscardedBytes))
+	return b.ReadCloser.Close()
+}
+
+// decodeJSON decodes the JSON response body of the Sentry API as it is read,
+// rather than reading it whole first.
+func decodeJSON(reader io.Reader, v interface{}) error {
+	return json.NewDecoder(reader).Decode(v)
+}
@@ -0,0 +1,102 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+)
+
+var (
+	cacheHitsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "sentry_exporter_cache_hits_total",
+		Help: "Number of probes served from the cached result of a previous probe",
+	}, []string{"module", "prober"})
+	cacheMissesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "sentry_exporter_cache_misses_total",
+		Help: "Number of probes of a cached prober which queried the Sentry API",
+	}, []string{"module", "prober"})
+)
+
+func init() {
+	prometheus.MustRegister(cacheHitsTotal, cacheMissesTotal)
+}
+
+type cachedResult struct {
+	registry *prometheus.Registry
+	expires  time.Time
+}
+
+// ResultCache holds the results of the successful probes of a module for
+// the cache_ttl of their prober, so that the Prometheus replicas of an HA
+// pair scraping one after the other query the Sentry API once.
+type ResultCache struct {
+	module  string
+	ttls    map[string]model.Duration
+	mu      sync.Mutex
+	results map[string]cachedResult
+}
+
+// newResultCache returns the result cache of the module, nil when none of
+// its probers is cached.
+func newResultCache(module string, ttls map[string]model.Duration) *ResultCache {
+	if len(ttls) == 0 {
+		return nil
+	}
+	return &ResultCache{
+		module:  module,
+		ttls:    ttls,
+		results: make(map[string]cachedResult),
+	}
+}
+
+// get returns the unexpired result of the probe with the given key.
+func (c *ResultCache) get(key, prober string) (*prometheus.Registry, bool) {
+	if c == nil || c.ttls[prober] <= 0 {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cached, ok := c.results[key]; ok && time.Now().Before(cached.expires) {
+		cacheHitsTotal.WithLabelValues(c.module, prober).Inc()
+		return cached.registry, true
+	}
+	cacheMissesTotal.WithLabelValues(c.module, prober).Inc()
+	return nil, false
+}
+
+// put caches the result of the probe with the given key if it succeeded,
+// failures being probed again on the next scrape.
+func (c *ResultCache) put(key, prober string, registry *prometheus.Registry) {
+	if c == nil || c.ttls[prober] <= 0 {
+		return
+	}
+	families, err := registry.Gather()
+	if err != nil || !probeSucceeded(families) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for k, cached := range c.results {
+		if !now.Before(cached.expires) {
+			delete(c.results, k)
+		}
+	}
+	c.results[key] = cachedResult{registry: registry, expires: now.Add(time.Duration(c.ttls[prober]))}
+}
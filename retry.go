@@ -0,0 +1,144 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+)
+
+// RetryOptions configures the retries of failed requests to the Sentry API.
+// Requests are retried on network errors, 429 and 5xx responses.
+type RetryOptions struct {
+	// Defaults to no retries.
+	Attempts int `yaml:"attempts"`
+	// Delay before the first retry, doubled on every further retry.
+	// Defaults to 500ms.
+	InitialBackoff model.Duration `yaml:"initial_backoff"`
+	// Defaults to 10s.
+	MaxBackoff model.Duration `yaml:"max_backoff"`
+}
+
+var apiRetriesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "sentry_exporter_retries_total",
+	Help: "Number of retried requests to the Sentry API",
+}, []string{"endpoint"})
+
+func init() {
+	prometheus.MustRegister(apiRetriesTotal)
+}
+
+func (o RetryOptions) initialBackoff() time.Duration {
+	if o.InitialBackoff > 0 {
+		return time.Duration(o.InitialBackoff)
+	}
+	return 500 * time.Millisecond
+}
+
+func (o RetryOptions) maxBackoff() time.Duration {
+	if o.MaxBackoff > 0 {
+		return time.Duration(o.MaxBackoff)
+	}
+	return 10 * time.Second
+}
+
+// do sends the request, retrying it with exponential backoff and jitter, or
+// after the delay requested by a Retry-After header. Retries which would wait
+// beyond max_backoff or the deadline of the request are not made, the last
+// response being returned instead.
+func (o RetryOptions) do(request *http.Request, endpoint string, client *http.Client, logger *Logger) (*http.Response, error) {
+	backoff := o.initialBackoff()
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		resp, err := client.Do(request)
+		duration := time.Since(start)
+		apiRequestDuration.WithLabelValues(endpoint).Observe(duration.Seconds())
+		if err != nil {
+			apiRequestsTotal.WithLabelValues(endpoint, "error").Inc()
+		} else {
+			apiRequestsTotal.WithLabelValues(endpoint, strconv.Itoa(resp.StatusCode)).Inc()
+			observeResponse(endpoint, resp, duration)
+		}
+		if attempt >= o.Attempts || !retryable(request, resp, err) {
+			return resp, err
+		}
+
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		if resp != nil {
+			if d, ok := retryAfter(resp); ok {
+				if d > o.maxBackoff() {
+					// Returned as is, so that the throttle hint is recorded
+					// rather than blocking the probe.
+					logger.Warnf("Not retrying request to %s, Retry-After %s exceeds max_backoff %s\n", request.URL, d, o.maxBackoff())
+					return resp, err
+				}
+				wait = d
+			}
+		}
+		if deadline, ok := request.Context().Deadline(); ok && time.Until(deadline) < wait {
+			logger.Warnf("Not retrying request to %s, the probe deadline is in less than %s\n", request.URL, wait)
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxDiscardedBytes))
+			resp.Body.Close()
+		}
+		logger.Warnf("Retrying request to %s in %s\n", request.URL, wait)
+		apiRetriesTotal.WithLabelValues(endpoint).Inc()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-request.Context().Done():
+			timer.Stop()
+			return nil, request.Context().Err()
+		}
+		backoff *= 2
+		if backoff > o.maxBackoff() {
+			backoff = o.maxBackoff()
+		}
+	}
+}
+
+func retryable(request *http.Request, resp *http.Response, err error) bool {
+	if err != nil {
+		return request.Context().Err() == nil
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+// retryAfter parses the Retry-After header of the response, given either in
+// seconds or as a date.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(v); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
@@ -0,0 +1,130 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Projects of the mock organization probed by the self test.
+const selftestProjects = 3
+
+// proberContract lists the metric families a built-in prober emits on every
+// successful probe, along with the probe parameters it needs to emit them.
+type proberContract struct {
+	metrics []string
+	params  url.Values
+}
+
+var proberContracts = map[string]proberContract{
+	"lag":               {metrics: []string{"sentry_events_lag_seconds", "sentry_project_lag_seconds", "sentry_events_1h_total", "sentry_fetch_failures"}},
+	"issues":            {metrics: []string{"sentry_high_freq_issues", "sentry_project_high_freq_issues"}},
+	"latest_event":      {metrics: []string{"sentry_latest_event_timestamp", "sentry_project_latest_event_timestamp", "sentry_fetch_failures"}},
+	"escalation":        {metrics: []string{"sentry_issue_escalating", "sentry_issue_events_24h"}, params: url.Values{"issue": {"1"}}},
+	"releases":          {metrics: []string{"sentry_release_age_seconds", "sentry_release_latest_created_timestamp", "sentry_fetch_failures"}},
+	"sessions":          {metrics: []string{"sentry_sessions_total", "sentry_crash_free_sessions_ratio"}},
+	"outcomes":          {metrics: []string{"sentry_project_outcomes_total"}},
+	"ingest":            {metrics: []string{"sentry_project_ingest_accepted_total", "sentry_ingest_accepted_total"}},
+	"processing_issues": {metrics: []string{"sentry_project_processing_issues", "sentry_fetch_failures"}},
+	"keys":              {metrics: []string{"sentry_project_key_active", "sentry_fetch_failures"}},
+	"monitors":          {metrics: []string{"sentry_monitor_status", "sentry_monitor_last_checkin_timestamp"}},
+	"incidents":         {metrics: []string{"sentry_alert_rule_info", "sentry_incident_open"}},
+	"issue_counts":      {metrics: []string{"sentry_project_issues", "sentry_project_new_issues"}},
+	"canary":            {metrics: []string{"sentry_events_lag_seconds", "sentry_project_lag_seconds", "sentry_project_key_rate_limit_events_per_second"}},
+	"teams":             {metrics: []string{"sentry_team_projects", "sentry_team_events_1h_total", "sentry_team_lag_seconds", "sentry_fetch_failures"}},
+}
+
+// selftestProber runs the prober of the module against the mock Sentry API
+// and returns why it broke its contract, nil when it kept it.
+func selftestProber(name string, module Module, prober string, r *http.Request) []string {
+	mock := newMockSentry(selftestProjects, 0)
+	defer mock.Close()
+	test := benchModule(name, module, mock)
+	test.HTTP.ctx = r.Context()
+	test.HTTP.CanaryProjects = []string{mock.projectSlug(1)}
+
+	contract := proberContracts[prober]
+	params := url.Values{}
+	for k, v := range contract.params {
+		params[k] = v
+	}
+	families, err := runProbe(name, Probers[prober], params, test).Gather()
+	if err != nil {
+		return []string{fmt.Sprintf("gathering the metrics failed: %s", err)}
+	}
+
+	var problems []string
+	if !probeSucceeded(families) {
+		problems = append(problems, "probe_success is 0")
+	}
+	emitted := make(map[string]bool)
+	for _, mf := range families {
+		emitted[mf.GetName()] = true
+	}
+	var missing []string
+	for _, m := range contract.metrics {
+		if !emitted[m] {
+			missing = append(missing, m)
+		}
+	}
+	if len(missing) > 0 {
+		problems = append(problems, "missing "+strings.Join(missing, ", "))
+	}
+	return problems
+}
+
+// selftestHandler runs every built-in prober enabled in each module against
+// the embedded mock Sentry API, with the module's prober options and metric
+// relabeling, and checks that it emits the metric families of its contract.
+// It answers with a line per prober, and a 503 when any of them failed.
+func selftestHandler(w http.ResponseWriter, r *http.Request, conf *Config) {
+	var names []string
+	for name := range conf.Modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var lines []string
+	failed := false
+	for _, name := range names {
+		module := conf.Modules[name]
+		var probers []string
+		for prober := range Probers {
+			if module.allowsProber(prober) && module.supportsProber(prober) {
+				probers = append(probers, prober)
+			}
+		}
+		sort.Strings(probers)
+
+		for _, prober := range probers {
+			if problems := selftestProber(name, module, prober, r); len(problems) > 0 {
+				failed = true
+				log.Errorf("Self test of prober %s of module %s failed: %s", prober, name, strings.Join(problems, "; "))
+				lines = append(lines, fmt.Sprintf("FAIL: module %s: prober %s: %s", name, prober, strings.Join(problems, "; ")))
+			} else {
+				lines = append(lines, fmt.Sprintf("PASS: module %s: prober %s", name, prober))
+			}
+		}
+	}
+
+	if failed {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	for _, line := range lines {
+		fmt.Fprintln(w, line)
+	}
+}
@@ -1,73 +1,164 @@
 package main
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"strings"
+	"sync"
 
-	"github.com/prometheus/common/log"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
-type ProjectListResponse struct {
-	Slug string
+type ProjectIDResponse struct {
+	ID   string `json:"id"`
+	Slug string `json:"slug"`
 }
 
-func extractSentryProjects(reader io.Reader) ([]string, error) {
-	var resp []ProjectListResponse
-	var projects []string
-	body, err := ioutil.ReadAll(reader)
-	if err != nil {
-		return projects, err
+// sentryProjectSlugs returns the slugs of the projects of the organization
+// keyed by project ID.
+func sentryProjectSlugs(config HTTPProbe, client *http.Client) (map[string]string, error) {
+	slugs := make(map[string]string)
+	err := requestSentryPages("organizations/"+config.Organization+"/projects/", config.Projects.maxPages(), config, client, func(reader io.Reader) error {
+		var projects []ProjectIDResponse
+		if err := decodeJSON(reader, &projects); err != nil {
+			return err
+		}
+		for _, p := range projects {
+			slugs[p.ID] = p.Slug
+		}
+		return nil
+	})
+	return slugs, err
+}
+
+// headersFor returns the headers to send for the given API path, applying
+// every matching endpoint override on top of the module's headers.
+func (p HTTPProbe) headersFor(path string) map[string]string {
+	if len(p.EndpointHeaders) == 0 {
+		return p.Headers
 	}
-	err = json.Unmarshal([]byte(body), &resp)
-	if err != nil {
-		return projects, err
+	headers := make(map[string]string)
+	for key, value := range p.Headers {
+		headers[http.CanonicalHeaderKey(key)] = value
 	}
-	for _, p := range resp {
-		projects = append(projects, p.Slug)
+	for _, e := range p.EndpointHeaders {
+		if !strings.HasPrefix(path, e.PathPrefix) {
+			continue
+		}
+		for key, value := range e.Headers {
+			headers[http.CanonicalHeaderKey(key)] = value
+		}
 	}
-	return projects, nil
+	return headers
 }
 
 func requestSentry(path string, config HTTPProbe, client *http.Client) (*http.Response, error) {
 	requestURL := config.Domain + "/api/0/" + path
+	if authFailures.coolingDown(config.authKey(path)) {
+		config.logger().Warnf("Not requesting %s: %s", path, errAuthCoolingDown)
+		return &http.Response{}, errAuthCoolingDown
+	}
+	if !config.apiBudget.take() {
+		config.logger().Warnf("Not requesting %s: %s", path, errAPIBudgetExhausted)
+		return &http.Response{}, errAPIBudgetExhausted
+	}
+
+	if config.plan != nil {
+		return config.plan.record(requestURL), nil
+	}
 
 	request, err := http.NewRequest("GET", requestURL, nil)
 	if err != nil {
-		log.Errorf("Error creating request for target %s: %s", path, err)
+		config.logger().Errorf("Error creating request for target %s: %s", path, err)
 		return &http.Response{}, err
 	}
+	request = request.WithContext(config.context())
 
-	for key, value := range config.Headers {
+	if config.bearerToken != "" {
+		request.Header.Set("Authorization", "Bearer "+config.bearerToken)
+	}
+	for key, value := range config.headersFor(path) {
 		if strings.Title(key) == "Host" {
 			request.Host = value
 			continue
 		}
 		request.Header.Set(key, value)
 	}
+	config.Audit.apply(request)
 
-	resp, err := client.Do(request)
+	endpoint := apiEndpoint(path)
+	if err := config.quota.pace(config.context(), endpoint, config.logger()); err != nil {
+		config.logger().Warnf("Error for HTTP request to %s: %s", path, err)
+		return &http.Response{}, err
+	}
+	release, err := config.endpointLimiter(endpoint).acquire(config.context())
+	if err != nil {
+		config.logger().Warnf("Error for HTTP request to %s: %s", path, err)
+		return &http.Response{}, err
+	}
+	if !config.followsRedirects() {
+		client = withoutRedirects(client)
+	}
+	resp, err := config.Retry.do(request, endpoint, client, config.logger())
+	// The slot is released once the response headers are received, so that
+	// requests made while reading a body of the same class cannot deadlock.
+	release()
+	if err == nil {
+		config.quota.observe(endpoint, resp)
+	}
 	// Err won't be nil if redirects were turned off. See https://github.com/golang/go/issues/3795
 	if err != nil {
-		log.Warnf("Error for HTTP request to %s: %s", path, err)
-	} else {
-		if len(config.ValidStatusCodes) != 0 {
-			for _, code := range config.ValidStatusCodes {
-				if resp.StatusCode == code {
-					return resp, nil
-				}
+		config.logger().Warnf("Error for HTTP request to %s: %s", path, err)
+		apiErrorsTotal.WithLabelValues(endpoint).Inc()
+		return &http.Response{}, err
+	}
+	if len(config.ValidStatusCodes) != 0 {
+		for _, code := range config.ValidStatusCodes {
+			if resp.StatusCode == code {
+				resp.Body = newResponseBody(resp.Body, endpoint, config.maxResponseBytes())
+				return resp, nil
 			}
-		} else if 200 <= resp.StatusCode && resp.StatusCode < 300 {
-			log.Debugf("received %d from %s\n", resp.StatusCode, requestURL)
-			return resp, nil
 		}
+	} else if 200 <= resp.StatusCode && resp.StatusCode < 300 {
+		config.logger().Debugf("received %d from %s\n", resp.StatusCode, requestURL)
+		resp.Body = newResponseBody(resp.Body, endpoint, config.maxResponseBytes())
+		return resp, nil
+	}
+	apiErrorsTotal.WithLabelValues(endpoint).Inc()
+	if isAuthFailure(path, resp.StatusCode) {
+		config.logger().Errorf("Sentry API rejected the credentials with %d, not requesting it for %s", resp.StatusCode, config.authFailureCooldown())
+		authFailures.fail(config.authKey(path), config.authFailureCooldown())
 	}
-	r, _ := ioutil.ReadAll(resp.Body)
-	return &http.Response{}, errors.New(fmt.Sprintf("Invalid response from Sentry API: %d\n%s", resp.StatusCode, string(r)))
+	if resp.StatusCode == http.StatusTooManyRequests {
+		if d, ok := retryAfter(resp); ok {
+			config.throttle.throttled(d)
+		}
+	}
+	defer resp.Body.Close()
+	// Only the start of the error message is kept.
+	r, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxDiscardedBytes))
+	return &http.Response{}, &sentryResponseError{statusCode: resp.StatusCode, body: string(r)}
+}
+
+// sentryResponseError is returned by requestSentry when the Sentry API
+// answers with an invalid status code.
+type sentryResponseError struct {
+	statusCode int
+	body       string
+}
+
+func (e *sentryResponseError) Error() string {
+	return fmt.Sprintf("Invalid response from Sentry API: %d\n%s", e.statusCode, e.body)
+}
+
+// isAccessDenied reports whether the request failed because the token is not
+// allowed to access the resource.
+func isAccessDenied(err error) bool {
+	e, ok := err.(*sentryResponseError)
+	return ok && e.statusCode == http.StatusForbidden
 }
 
 func getSentryNextCursor(resp *http.Response) (string, error) {
@@ -75,6 +166,10 @@ func getSentryNextCursor(resp *http.Response) (string, error) {
 	parts := strings.Split(link, ", ")
 	for _, part := range parts {
 		if strings.Contains(part, "rel=\"next\"") {
+			if strings.Contains(part, "results=\"false\"") {
+				// There is no next page.
+				return "", nil
+			}
 			semicolonParts := strings.Split(part, ";")
 			for _, p := range semicolonParts {
 				if strings.Contains(p, "cursor=\"") {
@@ -89,31 +184,192 @@ func getSentryNextCursor(resp *http.Response) (string, error) {
 	return "", errors.New("unable to identify next cursor")
 }
 
-func allSentryProjects(config HTTPProbe, client *http.Client, failures *int, w http.ResponseWriter) []string {
-	resp, err := requestSentry("organizations/"+config.Organization+"/projects/", config, client)
+// Hard ceiling on the number of pages fetched by a single paginated request.
+const maxSentryPages = 100
+
+// requestSentryPages requests every page of the paginated API path, up to
+// maxPages, and calls fn with the body of each of them.
+func requestSentryPages(path string, maxPages int, config HTTPProbe, client *http.Client, fn func(body io.Reader) error) error {
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	guard := newCursorGuard(maxPages)
+	cursor := ""
+	for {
+		if aborted := guard.next(cursor); aborted != "" {
+			config.logger().Errorf("Stopping pagination of %s at cursor '%s': %s", path, cursor, aborted)
+			return nil
+		}
+		pagePath := path
+		if cursor != "" {
+			pagePath += separator + cursor
+		}
+		resp, err := requestSentry(pagePath, config, client)
+		if err != nil {
+			return err
+		}
+		err = fn(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		// A missing Link header means there are no further pages.
+		cursor, _ = getSentryNextCursor(resp)
+		if cursor == "" {
+			return nil
+		}
+	}
+}
+
+// cursorGuard protects pagination loops against Sentry returning the same
+// cursor twice or never ending.
+type cursorGuard struct {
+	seen  map[string]bool
+	pages int
+	limit int
+}
+
+func newCursorGuard(limit int) *cursorGuard {
+	return &cursorGuard{seen: make(map[string]bool), limit: limit}
+}
+
+// next records that the page for cursor is about to be fetched. It returns
+// the reason pagination must stop instead, if any.
+func (g *cursorGuard) next(cursor string) string {
+	if g.seen[cursor] {
+		return "repeated_cursor"
+	}
+	if g.pages >= g.limit {
+		return "page_limit"
+	}
+	g.seen[cursor] = true
+	g.pages++
+	return ""
+}
+
+func registerPaginationAborted(registry *prometheus.Registry, reason string) {
+	abortedGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sentry_pagination_aborted",
+		Help: "Whether pagination was stopped because a cursor repeated or the page limit was hit",
+	}, []string{"reason"})
+	registry.MustRegister(abortedGauge)
+	for _, r := range []string{"repeated_cursor", "page_limit"} {
+		abortedGauge.WithLabelValues(r)
+	}
+	if reason != "" {
+		abortedGauge.WithLabelValues(reason).Set(1)
+	}
+}
 
+// allSentryProjects returns the slugs of every project of the organization,
+// along with the slugs of the teams of each project by project slug.
+func allSentryProjects(config HTTPProbe, client *http.Client) ([]string, map[string][]string, error) {
 	var projects []string
-	if err == nil {
-		projects, err = extractSentryProjects(resp.Body)
-		fmt.Fprintf(w, "sentry_projects_total %d\n", len(projects))
-	} else {
-		log.Error(err)
+	teams := make(map[string][]string)
+	err := requestSentryPages("organizations/"+config.Organization+"/projects/", config.Projects.maxPages(), config, client, func(reader io.Reader) error {
+		page, err := extractProjectTeams(reader)
+		if err != nil {
+			return err
+		}
+		for _, p := range page {
+			projects = append(projects, p.Slug)
+			teams[p.Slug] = p.teamSlugs()
+		}
+		return nil
+	})
+	if err != nil {
+		// The pages requested before the error are only part of the
+		// organization.
+		return nil, nil, err
+	}
+	return projects, teams, nil
+}
+
+// discoverProjects returns the projects of the organization and their teams,
+// exporting their number to the registry of the probe. If any page of them
+// could not be fetched, it counts the failure and returns nil and false.
+func discoverProjects(config HTTPProbe, client *http.Client, failures *int, registry *prometheus.Registry) ([]string, map[string][]string, bool) {
+	projects, teams, err := allSentryProjects(config, client)
+	if err != nil {
+		config.logger().Error(err)
 		*failures++
+		return nil, nil, false
 	}
 
-	return projects
+	projectsGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "sentry_projects_total",
+		Help: "Number of projects in the Sentry organization",
+	})
+	emptyGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "sentry_discovery_empty",
+		Help: "Whether the discovery of the projects of the Sentry organization found none",
+	})
+	registry.MustRegister(projectsGauge, emptyGauge)
+	projectsGauge.Set(float64(len(projects)))
+	if len(projects) == 0 {
+		config.logger().Warnf("No projects discovered in Sentry organization %s, is the token allowed to list them?", config.Organization)
+		emptyGauge.Set(1)
+	}
+	return projects, teams, true
 }
 
-var allProjectsCache []string
-var timesSinceUpdate = 0
+// getOrUpdateProjectsList returns the projects of the organization from the
+// module's project cache, if any.
+func getOrUpdateProjectsList(config HTTPProbe, client *http.Client, failures *int, registry *prometheus.Registry) []string {
+	if config.projectCache == nil {
+		projects, _, _ := discoverProjects(config, client, failures, registry)
+		return projects
+	}
+	return config.projectCache.get(config, client, failures, registry)
+}
 
-func getOrUpdateProjectsList(config HTTPProbe, client *http.Client, failures *int, w http.ResponseWriter) []string {
-	if len(allProjectsCache) == 0 || timesSinceUpdate > 50 {
-		allProjectsCache = allSentryProjects(config, client, failures, w)
-		timesSinceUpdate = 0
-	} else {
-		timesSinceUpdate++
+// projectFailures counts the failed requests of a probe per project, from
+// concurrent goroutines.
+type projectFailures struct {
+	mu       sync.Mutex
+	projects map[string]int
+}
+
+func newProjectFailures() *projectFailures {
+	return &projectFailures{projects: make(map[string]int)}
+}
+
+// add records the failed requests of a project, 0 included.
+func (f *projectFailures) add(project string, failures int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.projects[project] += failures
+}
+
+func (f *projectFailures) total() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	total := 0
+	for _, n := range f.projects {
+		total += n
 	}
+	return total
+}
+
+func (f *projectFailures) register(registry *prometheus.Registry) {
+	failuresGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sentry_project_fetch_failures",
+		Help: "Number of failed requests to the Sentry API for the project during the probe",
+	}, []string{"project"})
+	registry.MustRegister(failuresGauge)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for project, n := range f.projects {
+		failuresGauge.WithLabelValues(project).Set(float64(n))
+	}
+}
 
-	return allProjectsCache
+func registerFetchFailures(registry *prometheus.Registry, failures int) {
+	failuresGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "sentry_fetch_failures",
+		Help: "Number of failed requests to the Sentry API during the probe",
+	})
+	registry.MustRegister(failuresGauge)
+	failuresGauge.Set(float64(failures))
 }
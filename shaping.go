@@ -0,0 +1,114 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// EndpointLimit limits the requests of a module to a class of Sentry API
+// endpoints, shared by all the probes of the module. Endpoint is a regular
+// expression matched against the whole endpoint as reported in the endpoint
+// label of sentry_exporter_api_requests_total, e.g.
+// projects/:organization/:project/stats/.
+type EndpointLimit struct {
+	Endpoint string `yaml:"endpoint"`
+	// Maximum number of requests in flight. Defaults to no limit.
+	MaxConcurrency int `yaml:"max_concurrency"`
+	// Defaults to no limit.
+	RequestsPerSecond float64 `yaml:"requests_per_second"`
+}
+
+// endpointLimiter enforces an EndpointLimit.
+type endpointLimiter struct {
+	endpoint *regexp.Regexp
+	slots    chan struct{}
+	interval time.Duration
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+// loadEndpointLimits compiles the endpoint limits of the module. It is called
+// on every config load, so the limits are shared by the probes of a module
+// until the next reload.
+func (p *HTTPProbe) loadEndpointLimits() error {
+	p.endpointLimiters = nil
+	for _, l := range p.EndpointLimits {
+		re, err := regexp.Compile("^(?:" + l.Endpoint + ")$")
+		if err != nil {
+			return fmt.Errorf("invalid endpoint_limits endpoint %q: %s", l.Endpoint, err)
+		}
+		if l.MaxConcurrency < 0 || l.RequestsPerSecond < 0 {
+			return fmt.Errorf("endpoint_limits of %q must not be negative", l.Endpoint)
+		}
+		limiter := &endpointLimiter{endpoint: re}
+		if l.MaxConcurrency > 0 {
+			limiter.slots = make(chan struct{}, l.MaxConcurrency)
+		}
+		if l.RequestsPerSecond > 0 {
+			limiter.interval = time.Duration(float64(time.Second) / l.RequestsPerSecond)
+		}
+		p.endpointLimiters = append(p.endpointLimiters, limiter)
+	}
+	return nil
+}
+
+// endpointLimiter returns the limiter of the first endpoint limit matching
+// the endpoint, or nil.
+func (p HTTPProbe) endpointLimiter(endpoint string) *endpointLimiter {
+	for _, l := range p.endpointLimiters {
+		if l.endpoint.MatchString(endpoint) {
+			return l
+		}
+	}
+	return nil
+}
+
+// acquire waits for the rate limit and a free slot, and returns the function
+// releasing the slot.
+func (l *endpointLimiter) acquire(ctx context.Context) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	if l.interval > 0 {
+		l.mu.Lock()
+		now := time.Now()
+		if l.next.Before(now) {
+			l.next = now
+		}
+		wait := l.next.Sub(now)
+		l.next = l.next.Add(l.interval)
+		l.mu.Unlock()
+		if wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+	}
+	if l.slots == nil {
+		return func() {}, nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return func() { <-l.slots }, nil
+}
@@ -0,0 +1,200 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Sharding splits the discovered projects of every module between the
+// replicas of the exporter, each replica probing the projects of its shard.
+// Projects are assigned with rendezvous hashing, so that changing the
+// number of shards only moves the projects of the added or removed shards.
+type Sharding struct {
+	Count int
+	Index int
+}
+
+func (s Sharding) enabled() bool {
+	return s.Count > 1
+}
+
+func (s Sharding) validate() error {
+	if s.Count < 1 {
+		return fmt.Errorf("the shard count must be at least 1, got %d", s.Count)
+	}
+	if s.Index < 0 || s.Index >= s.Count {
+		return fmt.Errorf("the shard index must be between 0 and %d, got %d", s.Count-1, s.Index)
+	}
+	return nil
+}
+
+// owner returns the shard owning the project of the organization, the one
+// with the highest hash of the shard and project.
+func (s Sharding) owner(org, project string) int {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s/%s", org, project)
+	key := h.Sum64()
+
+	owner := 0
+	var highest uint64
+	for shard := 0; shard < s.Count; shard++ {
+		if sum := mix64(key ^ uint64(shard)*0x9e3779b97f4a7c15); shard == 0 || sum > highest {
+			owner, highest = shard, sum
+		}
+	}
+	return owner
+}
+
+// mix64 is the finalizer of SplitMix64, spreading the projects evenly
+// between the shards where FNV alone would not.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// owns returns whether the project of the organization belongs to the shard
+// of this replica, always when sharding is disabled.
+func (s Sharding) owns(org, project string) bool {
+	return !s.enabled() || s.owner(org, project) == s.Index
+}
+
+// shardProjects returns the projects of the organization owned by the shard
+// of this replica.
+func (s Sharding) shardProjects(org string, projects []string) []string {
+	if !s.enabled() {
+		return projects
+	}
+	var owned []string
+	for _, p := range projects {
+		if s.owns(org, p) {
+			owned = append(owned, p)
+		}
+	}
+	return owned
+}
+
+// shardsResponse describes the assignment of the projects of every module
+// to the shards.
+type shardsResponse struct {
+	Count   int                                    `json:"count"`
+	Index   int                                    `json:"index"`
+	Modules map[string]map[string]map[int][]string `json:"modules"`
+}
+
+// shardAssignments returns the discovered projects of every organization of
+// the module by owning shard, using the project cache of the module.
+func shardAssignments(module Module, sharding Sharding) map[string]map[int][]string {
+	orgs := make(map[string]map[int][]string)
+	for _, org := range module.HTTP.organizations() {
+		config := module.HTTP.forOrganization(org)
+		client := clientWithTimeout(url.Values{}, config.Lag.Timeout, config.transport)
+		failures := 0
+		shards := make(map[int][]string)
+		for shard := 0; shard < sharding.Count; shard++ {
+			shards[shard] = []string{}
+		}
+		for _, p := range config.Projects.filter(getOrUpdateProjectsList(config, client, &failures, prometheus.NewRegistry())) {
+			owner := sharding.owner(org, p)
+			shards[owner] = append(shards[owner], p)
+		}
+		for _, projects := range shards {
+			sort.Strings(projects)
+		}
+		orgs[org] = shards
+	}
+	return orgs
+}
+
+// shardsHandler answers with the owning shard of every discovered project of
+// the probeable modules, so that operators can check that the replicas
+// cover every project once.
+func shardsHandler(w http.ResponseWriter, r *http.Request, conf *Config, sharding Sharding) {
+	if !sharding.enabled() {
+		http.Error(w, "Sharding is not enabled, see --shard.count", http.StatusNotFound)
+		return
+	}
+	resp := shardsResponse{
+		Count:   sharding.Count,
+		Index:   sharding.Index,
+		Modules: make(map[string]map[string]map[int][]string),
+	}
+	for _, name := range conf.probeableModules() {
+		module, _ := conf.lookupModule(name)
+		module.HTTP.ctx = r.Context()
+		resp.Modules[name] = shardAssignments(module, sharding)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Errorf("Error writing shards: %s", err)
+	}
+}
+
+// shardCollector exports the number of cached projects of every module per
+// owning shard, without requesting the Sentry API.
+type shardCollector struct {
+	sc       *SafeConfig
+	sharding Sharding
+}
+
+var shardProjectsDesc = prometheus.NewDesc(
+	"sentry_exporter_shard_projects",
+	"Number of discovered projects of the module owned by the shard",
+	[]string{"module", "shard"}, nil,
+)
+
+func (c shardCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- shardProjectsDesc
+}
+
+func (c shardCollector) Collect(ch chan<- prometheus.Metric) {
+	c.sc.RLock()
+	conf := c.sc.C
+	c.sc.RUnlock()
+
+	for name, module := range conf.Modules {
+		if module.HTTP.projectCache == nil {
+			continue
+		}
+		counts := make([]int, c.sharding.Count)
+		cached := false
+		for _, org := range module.HTTP.organizations() {
+			projects, ok := module.HTTP.projectCache.cached(org)
+			if !ok {
+				continue
+			}
+			cached = true
+			for _, p := range module.HTTP.Projects.filter(projects) {
+				counts[c.sharding.owner(org, p)]++
+			}
+		}
+		if !cached {
+			continue
+		}
+		for shard, count := range counts {
+			ch <- prometheus.MustNewConstMetric(shardProjectsDesc, prometheus.GaugeValue, float64(count), name, strconv.Itoa(shard))
+		}
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var moduleDataAgeDesc = prometheus.NewDesc(
+	"sentry_module_data_age_seconds",
+	"Seconds since the last successful probe of the module, either live or in the background",
+	[]string{"module"}, nil,
+)
+
+// refreshTracker records when the data of each module was last successfully
+// refreshed from Sentry.
+type refreshTracker struct {
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+var moduleRefreshes = &refreshTracker{last: make(map[string]time.Time)}
+
+func init() {
+	prometheus.MustRegister(moduleRefreshes)
+}
+
+func (t *refreshTracker) markRefreshed(module string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.last[module] = time.Now()
+}
+
+func (t *refreshTracker) Describe(ch chan<- *prometheus.Desc) {
+	ch <- moduleDataAgeDesc
+}
+
+func (t *refreshTracker) Collect(ch chan<- prometheus.Metric) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for module, last := range t.last {
+		ch <- prometheus.MustNewConstMetric(moduleDataAgeDesc, prometheus.GaugeValue, time.Since(last).Seconds(), module)
+	}
+}
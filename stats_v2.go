@@ -0,0 +1,84 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var errNoMatchingProject = errors.New("no project of the organization matches the target")
+
+// statsGroup is the quantity of the items of a category and outcome of a
+// project over the period, from the stats_v2 API.
+type statsGroup struct {
+	project  string
+	category string
+	outcome  string
+	quantity float64
+}
+
+// requestOrgStats requests the quantities of the items of the categories
+// over the period of the projects resolved from the target, grouped by
+// project, category and outcome, with a single request to the stats_v2 API.
+// The projects are resolved like the lag probe's, filtered by the module's
+// project filter and shard. When none matches the target, no request is
+// made and errNoMatchingProject is returned.
+func requestOrgStats(target string, categories []string, period string, config HTTPProbe, client *http.Client, failures *int, registry *prometheus.Registry) ([]statsGroup, error) {
+	slugs, all := resolveProjectIDs(target, config, client, failures, registry)
+	if len(slugs) == 0 {
+		// Without a project parameter Sentry would answer for the whole
+		// organization.
+		return nil, errNoMatchingProject
+	}
+
+	query := url.Values{}
+	query.Set("field", "sum(quantity)")
+	query.Add("groupBy", "project")
+	query.Add("groupBy", "outcome")
+	query.Add("groupBy", "category")
+	for _, category := range categories {
+		query.Add("category", category)
+	}
+	query.Set("statsPeriod", period)
+	query.Set("interval", period)
+	addProjectIDs(query, slugs, all)
+
+	resp, err := requestSentry("organizations/"+config.Organization+"/stats_v2/?"+query.Encode(), config, client)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	stats, err := extractSessions(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var groups []statsGroup
+	for _, group := range stats.Groups {
+		quantity := group.Totals["sum(quantity)"]
+		if quantity == nil {
+			continue
+		}
+		projectID := fmt.Sprint(group.By["project"])
+		project, ok := slugs[projectID]
+		if !ok {
+			project = projectID
+		}
+		groups = append(groups, statsGroup{project, fmt.Sprint(group.By["category"]), fmt.Sprint(group.By["outcome"]), *quantity})
+	}
+	return groups, nil
+}
@@ -0,0 +1,248 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+)
+
+// ProjectFilter restricts the discovered projects which are probed. Both
+// lists hold regular expressions matched against the whole project slug. A
+// project is probed if it matches any of Include, or Include is empty, and
+// none of Exclude.
+type ProjectFilter struct {
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+	// Maximum number of pages of the organization's projects list which
+	// are fetched. Defaults to 100.
+	MaxPages int `yaml:"max_pages"`
+	// Time for which the discovered projects are cached. Defaults to 10m.
+	CacheTTL model.Duration `yaml:"cache_ttl"`
+
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+func (f ProjectFilter) maxPages() int {
+	if f.MaxPages > 0 {
+		return f.MaxPages
+	}
+	return maxSentryPages
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	var res []*regexp.Regexp
+	for _, p := range patterns {
+		re, err := regexp.Compile("^(?:" + p + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid project pattern %q: %s", p, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+func (f *ProjectFilter) compile() (err error) {
+	if f.include, err = compilePatterns(f.Include); err != nil {
+		return err
+	}
+	f.exclude, err = compilePatterns(f.Exclude)
+	return err
+}
+
+func (f ProjectFilter) allows(project string) bool {
+	for _, re := range f.exclude {
+		if re.MatchString(project) {
+			return false
+		}
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, re := range f.include {
+		if re.MatchString(project) {
+			return true
+		}
+	}
+	return false
+}
+
+// filter returns the projects allowed by the filter.
+func (f ProjectFilter) filter(projects []string) []string {
+	var allowed []string
+	for _, p := range projects {
+		if f.allows(p) {
+			allowed = append(allowed, p)
+		}
+	}
+	return allowed
+}
+
+// targetParam returns the target parameter of a probe, the values of
+// repeated target parameters being joined as a comma-separated list.
+func targetParam(values url.Values) string {
+	return strings.Join(values["target"], ",")
+}
+
+// targetPattern returns the matcher of a target pattern: a regular
+// expression such as ~"^api-.*" or ~^api-.*, matched against the whole
+// project slug, or a glob such as frontend-*. It is nil for project slugs.
+func targetPattern(t string) (func(string) bool, error) {
+	if strings.HasPrefix(t, "~") {
+		expr := strings.TrimPrefix(t, "~")
+		if len(expr) >= 2 && strings.HasPrefix(expr, `"`) && strings.HasSuffix(expr, `"`) {
+			expr = expr[1 : len(expr)-1]
+		}
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid target regular expression %q: %s", expr, err)
+		}
+		return re.MatchString, nil
+	}
+	if !strings.ContainsAny(t, "*?[") {
+		return nil, nil
+	}
+	if _, err := path.Match(t, ""); err != nil {
+		return nil, fmt.Errorf("invalid target glob %q: %s", t, err)
+	}
+	return func(project string) bool {
+		ok, _ := path.Match(t, project)
+		return ok
+	}, nil
+}
+
+// resolveTargets turns the target parameter of a probe into the list of
+// projects to probe. An empty target selects every discovered project, and a
+// target of the form "!project-a,!project-b" selects every discovered project
+// except the listed ones. A target such as "project-a,project-b" selects
+// the listed projects as is, without discovering the projects of the
+// organization, negated projects being left out. Globs such as frontend-*
+// and a regular expression such as ~"^api-.*", which is the whole target so
+// that it may contain commas, select the matching discovered projects.
+// The discovered projects are filtered by the module's project filter and,
+// when sharding is enabled, only those of the shard of this replica are
+// probed. Pinned targets are probed as is.
+func resolveTargets(target string, config HTTPProbe, client *http.Client, failures *int, registry *prometheus.Registry) []string {
+	if config.pinnedTargets != nil {
+		return config.pinnedTargets
+	}
+	discovered := func() []string {
+		return config.shard.shardProjects(config.Organization, config.Projects.filter(getOrUpdateProjectsList(config, client, failures, registry)))
+	}
+	if target == "" {
+		return discovered()
+	}
+
+	entries := strings.Split(target, ",")
+	if strings.HasPrefix(strings.TrimSpace(target), "~") {
+		entries = []string{strings.TrimSpace(target)}
+	}
+	excluded := make(map[string]bool)
+	var listed []string
+	var patterns []func(string) bool
+	for _, t := range entries {
+		t = strings.TrimSpace(t)
+		if strings.HasPrefix(t, "!") {
+			excluded[strings.TrimPrefix(t, "!")] = true
+			continue
+		}
+		match, err := targetPattern(t)
+		if err != nil {
+			config.logger().Error(err)
+			*failures++
+			return nil
+		}
+		if match != nil {
+			patterns = append(patterns, match)
+		} else if t != "" {
+			listed = append(listed, t)
+		}
+	}
+
+	var targets []string
+	seen := make(map[string]bool)
+	add := func(project string) {
+		if !excluded[project] && !seen[project] {
+			seen[project] = true
+			targets = append(targets, project)
+		}
+	}
+	for _, project := range listed {
+		add(project)
+	}
+	switch {
+	case len(patterns) > 0:
+		for _, project := range discovered() {
+			for _, match := range patterns {
+				if match(project) {
+					add(project)
+					break
+				}
+			}
+		}
+	case len(listed) == 0:
+		for _, project := range discovered() {
+			add(project)
+		}
+	}
+	return targets
+}
+
+// resolveProjectIDs resolves the target of a probe like resolveTargets, for
+// the probers making a single request for the projects of the organization.
+// It returns the slugs of the resolved projects of the organization keyed by
+// project ID, and whether they are all its projects, in which case the
+// request needs no project parameter. Listed projects the organization
+// doesn't have are left out.
+func resolveProjectIDs(target string, config HTTPProbe, client *http.Client, failures *int, registry *prometheus.Registry) (map[string]string, bool) {
+	resolved := resolveTargets(target, config, client, failures, registry)
+	if len(resolved) == 0 {
+		return nil, false
+	}
+	slugs, err := sentryProjectSlugs(config, client)
+	if err != nil {
+		config.logger().Error(err)
+		*failures++
+		return nil, false
+	}
+	wanted := make(map[string]bool, len(resolved))
+	for _, t := range resolved {
+		wanted[t] = true
+	}
+	ids := make(map[string]string)
+	for id, slug := range slugs {
+		if wanted[slug] {
+			ids[id] = slug
+		}
+	}
+	return ids, len(ids) == len(slugs)
+}
+
+// addProjectIDs adds a project parameter per project to the query of a
+// request for the projects of the organization, unless they are all of them.
+func addProjectIDs(query url.Values, ids map[string]string, all bool) {
+	if all {
+		return
+	}
+	for id := range ids {
+		query.Add("project", id)
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	"gopkg.in/yaml.v2"
+)
+
+var templateVariable = regexp.MustCompile(`\$\{(\w+)\}`)
+
+// substitute replaces the ${name} references to the variables in the raw
+// yaml value, in keys as well as values. Other references are left as is,
+// e.g. environment variables expanded in bearer tokens.
+func substitute(raw interface{}, vars map[string]string) interface{} {
+	switch v := raw.(type) {
+	case string:
+		return templateVariable.ReplaceAllStringFunc(v, func(ref string) string {
+			if value, ok := vars[templateVariable.FindStringSubmatch(ref)[1]]; ok {
+				return value
+			}
+			return ref
+		})
+	case map[interface{}]interface{}:
+		m := make(map[interface{}]interface{}, len(v))
+		for key, value := range v {
+			m[substitute(key, vars)] = substitute(value, vars)
+		}
+		return m
+	case []interface{}:
+		items := make([]interface{}, len(v))
+		for i, value := range v {
+			items[i] = substitute(value, vars)
+		}
+		return items
+	}
+	return raw
+}
+
+// expandModuleTemplates returns the config file with a module added for each
+// item of the for_each list of every module template, so that the instances
+// are validated and loaded like the other modules.
+func expandModuleTemplates(data []byte) ([]byte, error) {
+	var templates struct {
+		ModuleTemplates []ModuleTemplate `yaml:"module_templates"`
+	}
+	if err := yaml.Unmarshal(data, &templates); err != nil || len(templates.ModuleTemplates) == 0 {
+		// Errors are reported when parsing the config itself.
+		return data, nil
+	}
+	var raw map[interface{}]interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return data, nil
+	}
+	modules, _ := raw["modules"].(map[interface{}]interface{})
+	if modules == nil {
+		modules = make(map[interface{}]interface{})
+	}
+
+	for i, t := range templates.ModuleTemplates {
+		if t.Name == "" {
+			return nil, fmt.Errorf("module template %d has no name", i)
+		}
+		for _, vars := range t.ForEach {
+			name := substitute(t.Name, vars).(string)
+			if _, ok := modules[name]; ok {
+				return nil, fmt.Errorf("module %q of template %q is already defined", name, t.Name)
+			}
+			modules[name] = substitute(t.Module, vars)
+		}
+	}
+	raw["modules"] = modules
+	return yaml.Marshal(raw)
+}
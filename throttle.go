@@ -0,0 +1,50 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// throttleHint records the latest time until which the Sentry API asked,
+// with the Retry-After header of a 429 response, not to be requested during
+// a probe.
+type throttleHint struct {
+	mu    sync.Mutex
+	until time.Time
+}
+
+// throttled records a 429 response asking to wait for d, keeping the latest
+// requested time across the requests of the probe.
+func (t *throttleHint) throttled(d time.Duration) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if until := time.Now().Add(d); until.After(t.until) {
+		t.until = until
+	}
+}
+
+// deferredUntil returns the time until which the Sentry API asked not to be
+// requested, zero if it didn't.
+func (t *throttleHint) deferredUntil() time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.until
+}
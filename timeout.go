@@ -0,0 +1,56 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// scrapeTimeout returns the scrape timeout Prometheus sends with the
+// request, minus offset to leave time to serve the response.
+func scrapeTimeout(r *http.Request, offset time.Duration) (time.Duration, bool) {
+	v := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds")
+	if v == "" {
+		return 0, false
+	}
+	seconds, err := strconv.ParseFloat(v, 64)
+	if err != nil || seconds <= 0 {
+		return 0, false
+	}
+	timeout := time.Duration(seconds * float64(time.Second))
+	if timeout > offset {
+		timeout -= offset
+	}
+	return timeout, true
+}
+
+// context returns the context of the probe, which carries the deadline of
+// the scrape if Prometheus sent one.
+func (p HTTPProbe) context() context.Context {
+	if p.ctx != nil {
+		return p.ctx
+	}
+	return context.Background()
+}
+
+// deadline returns the earliest of the given deadline and the deadline of
+// the probe's context. A zero time means no deadline.
+func (p HTTPProbe) deadline(d time.Time) time.Time {
+	if ctxDeadline, ok := p.context().Deadline(); ok && (d.IsZero() || ctxDeadline.Before(d)) {
+		return ctxDeadline
+	}
+	return d
+}
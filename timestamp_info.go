@@ -0,0 +1,89 @@
+// Copyright 2019 Paolo Garri.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// timestampInfo returns whether the probe exports the timestamps in a
+// readable form, as configured in the module or asked with
+// ?timestamp_info=true.
+func (m Module) timestampInfo(params url.Values) bool {
+	return m.TimestampInfo || params.Get("timestamp_info") == "true"
+}
+
+// timestampLocation returns the location the readable timestamps are
+// formatted in, UTC by default. The location is checked when loading the
+// config.
+func (m Module) timestampLocation() *time.Location {
+	if m.TimestampLocation == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(m.TimestampLocation)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// registerTimestampInfo exports, for every series of a gauge whose name ends
+// with _timestamp, a <name>_info series with the same labels and the time
+// in RFC3339 in a time label, so that the probe output can be read without
+// converting Unix timestamps. Unset timestamps are skipped.
+func registerTimestampInfo(registry *prometheus.Registry, loc *time.Location, logger *Logger) {
+	mfs, err := registry.Gather()
+	if err != nil {
+		logger.Errorf("Error gathering probe metrics for timestamp info: %s", err)
+		return
+	}
+	existing := make(map[string]bool)
+	for _, mf := range mfs {
+		existing[mf.GetName()] = true
+	}
+
+	info := make(familiesCollector)
+	for _, mf := range mfs {
+		name := mf.GetName() + "_info"
+		if !strings.HasSuffix(mf.GetName(), "_timestamp") || mf.GetType() != dto.MetricType_GAUGE || existing[name] {
+			continue
+		}
+		out := &dto.MetricFamily{
+			Name: proto.String(name),
+			Help: proto.String("Time of " + mf.GetName() + " in RFC3339, in the time label"),
+			Type: dto.MetricType_GAUGE.Enum(),
+		}
+		for _, m := range mf.Metric {
+			ts := m.GetGauge().GetValue()
+			if ts <= 0 {
+				continue
+			}
+			t := time.Unix(0, int64(ts*float64(time.Second))).In(loc)
+			labels := append([]*dto.LabelPair{}, m.Label...)
+			labels = append(labels, &dto.LabelPair{Name: proto.String("time"), Value: proto.String(t.Format(time.RFC3339))})
+			out.Metric = append(out.Metric, &dto.Metric{Label: labels, Gauge: &dto.Gauge{Value: proto.Float64(1)}})
+		}
+		if len(out.Metric) > 0 {
+			info[name] = out
+		}
+	}
+	if len(info) > 0 {
+		registry.MustRegister(info)
+	}
+}